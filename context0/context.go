@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"github.com/google/uuid"
 	"google.golang.org/grpc/metadata"
+	"net/http"
+	"strings"
 	"sync"
 )
 
@@ -18,8 +20,45 @@ const (
 	ContextKeyCurrentMethod   = "temp_current_method"
 	ContextKeyCurrentService  = "temp_current_service"
 	contextMeta               = "meta_data"
+	HttpHeaderTraceID         = "X-Trace-Id"
+	HttpHeaderMetaPrefix      = "X-Meta-"
 )
 
+var (
+	// the framework keys describe this hop and are never taken from an http client
+	reservedKeys = map[string]bool{
+		ContextKeyTraceID:         true,
+		ContextKeyUpstreamService: true,
+		ContextKeyUpstreamMethod:  true,
+		ContextKeyUpstreamAddress: true,
+		ContextKeyCurrentMethod:   true,
+		ContextKeyCurrentService:  true,
+	}
+	httpMetaKeys     = make(map[string]bool)
+	httpMetaKeysLock sync.RWMutex
+)
+
+// AllowHttpMeta lets the metadata keys pass between http headers (X-Meta-Key-Name for key_name) and context,
+// only the trace ID passes by default, the framework keys are never allowed
+func AllowHttpMeta(keys ...string) {
+	httpMetaKeysLock.Lock()
+	defer httpMetaKeysLock.Unlock()
+
+	for _, key := range keys {
+		key = strings.ToLower(key)
+		if !reservedKeys[key] {
+			httpMetaKeys[key] = true
+		}
+	}
+}
+
+func isHttpMetaAllowed(key string) bool {
+	httpMetaKeysLock.RLock()
+	defer httpMetaKeysLock.RUnlock()
+
+	return httpMetaKeys[key]
+}
+
 type metaDataInner struct {
 	metaData metadata.MD
 	sync.RWMutex
@@ -87,6 +126,72 @@ func FromRpcContext(ctx context.Context) context.Context {
 	return context.WithValue(ctx, contextMeta, &metaDataInner{metaData: meta})
 }
 
+// ValidTraceID reports whether a trace ID taken from a client can be logged and passed on as is,
+// it has at most 64 letters, digits, '.', '_' or '-'
+func ValidTraceID(traceID string) bool {
+	if traceID == "" || len(traceID) > 64 {
+		return false
+	}
+
+	for _, c := range traceID {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// FromHttpHeader continues the trace of an incoming http request, a new trace ID is created when there is none
+// or it is not valid,
+// X-Meta-* headers are imported only for the keys of AllowHttpMeta
+func FromHttpHeader(header http.Header) context.Context {
+	traceID := header.Get(HttpHeaderTraceID)
+	if !ValidTraceID(traceID) {
+		traceID = uuid.New().String()
+	}
+
+	meta := metadata.Pairs(ContextKeyTraceID, traceID)
+	for key, values := range header {
+		if len(values) == 0 || !strings.HasPrefix(key, HttpHeaderMetaPrefix) {
+			continue
+		}
+
+		metaKey := strings.ToLower(strings.ReplaceAll(strings.TrimPrefix(key, HttpHeaderMetaPrefix), "-", "_"))
+		if isHttpMetaAllowed(metaKey) {
+			meta.Set(metaKey, values[0])
+		}
+	}
+
+	return context.WithValue(context.TODO(), contextMeta, &metaDataInner{metaData: meta})
+}
+
+// ToHttpHeader writes the trace ID and the metadata keys of AllowHttpMeta to an outgoing http request
+func ToHttpHeader(ctx context.Context, header http.Header) {
+	meta, ok := ctx.Value(contextMeta).(*metaDataInner)
+	if !ok {
+		header.Set(HttpHeaderTraceID, uuid.New().String())
+		return
+	}
+
+	meta.RLock()
+	defer meta.RUnlock()
+	for key, values := range meta.metaData {
+		if len(values) == 0 {
+			continue
+		}
+
+		if key == ContextKeyTraceID {
+			header.Set(HttpHeaderTraceID, values[0])
+			continue
+		}
+
+		if isHttpMetaAllowed(key) {
+			header.Set(HttpHeaderMetaPrefix+strings.ReplaceAll(key, "_", "-"), values[0])
+		}
+	}
+}
+
 func Copy(from context.Context) context.Context {
 	meta, ok := from.Value(contextMeta).(*metaDataInner)
 	if !ok {
@@ -0,0 +1,54 @@
+package context0
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestFromHttpHeaderAllowList(t *testing.T) {
+	AllowHttpMeta("Region", ContextKeyUpstreamService)
+
+	header := http.Header{}
+	header.Set(HttpHeaderTraceID, "trace-1")
+	header.Set("X-Meta-Region", "eu")
+	header.Set("X-Meta-User-Id", "1")
+	header.Set("X-Meta-Temp-Upstream-Service", "admin")
+	header.Set("X-Meta-Persist-Trace-Id", "forged")
+	ctx := FromHttpHeader(header)
+
+	if traceID, _ := Get(ctx, ContextKeyTraceID); traceID != "trace-1" {
+		t.Fatalf("got trace ID %q", traceID)
+	}
+	if region, _ := Get(ctx, "region"); region != "eu" {
+		t.Fatalf("allowed key got %q", region)
+	}
+	if _, exist := Get(ctx, "user_id"); exist {
+		t.Fatal("key not allowed was imported")
+	}
+	if _, exist := Get(ctx, ContextKeyUpstreamService); exist {
+		t.Fatal("framework key was imported from a client")
+	}
+
+	out := http.Header{}
+	ToHttpHeader(ctx, out)
+	if out.Get(HttpHeaderTraceID) != "trace-1" || out.Get("X-Meta-Region") != "eu" || len(out) != 2 {
+		t.Fatalf("got header %v", out)
+	}
+}
+
+func TestFromHttpHeaderTraceID(t *testing.T) {
+	header := http.Header{}
+	header.Set(HttpHeaderTraceID, "Trace_1.a-"+strings.Repeat("b", 54))
+	if traceID, _ := Get(FromHttpHeader(header), ContextKeyTraceID); traceID != header.Get(HttpHeaderTraceID) {
+		t.Fatalf("valid trace ID replaced by %q", traceID)
+	}
+
+	for _, invalid := range []string{"", strings.Repeat("a", 65), "a b", "a\nfake log", "a\u00e9", "a/b"} {
+		header.Set(HttpHeaderTraceID, invalid)
+		traceID, _ := Get(FromHttpHeader(header), ContextKeyTraceID)
+		if traceID == invalid || len(traceID) != 36 || !ValidTraceID(traceID) {
+			t.Fatalf("trace ID %q got %q, want a new uuid", invalid, traceID)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package discovery
+
+import (
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/balancer/roundrobin"
+	"google.golang.org/grpc/resolver"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	BALANCER_ROUND_ROBIN   = roundrobin.Name
+	BALANCER_WEIGHTED      = "weighted"
+	BALANCER_LEAST_REQUEST = "least_request"
+)
+
+func init() {
+	balancer.Register(&pickerBalancerBuilder{name: BALANCER_WEIGHTED, newPickerBuilder: func() addressPickerBuilder {
+		return &weightedPickerBuilder{}
+	}})
+	balancer.Register(&pickerBalancerBuilder{name: BALANCER_LEAST_REQUEST, newPickerBuilder: func() addressPickerBuilder {
+		return &leastRequestPickerBuilder{inFlights: make(map[balancer.SubConn]*int64)}
+	}})
+}
+
+// addressPickerBuilder also sees the resolved addresses, the base balancer keeps the attributes an address
+// had when its SubConn was created
+type addressPickerBuilder interface {
+	base.PickerBuilder
+	updateAddresses(addresses []resolver.Address)
+}
+
+// pickerBalancerBuilder gives every ClientConn its own picker builder so the state kept between pickers
+// goes away with the ClientConn
+type pickerBalancerBuilder struct {
+	name             string
+	newPickerBuilder func() addressPickerBuilder
+}
+
+func (b *pickerBalancerBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	pickerBuilder := b.newPickerBuilder()
+	return &pickerBalancer{
+		Balancer:      base.NewBalancerBuilder(b.name, pickerBuilder, base.Config{HealthCheck: true}).Build(cc, opts),
+		pickerBuilder: pickerBuilder,
+	}
+}
+
+func (b *pickerBalancerBuilder) Name() string {
+	return b.name
+}
+
+// pickerBalancer is called by grpc one method at a time, pickers are built inside these calls
+type pickerBalancer struct {
+	balancer.Balancer
+	pickerBuilder addressPickerBuilder
+}
+
+func (b *pickerBalancer) UpdateClientConnState(state balancer.ClientConnState) error {
+	b.pickerBuilder.updateAddresses(state.ResolverState.Addresses)
+	return b.Balancer.UpdateClientConnState(state)
+}
+
+func weightOf(address resolver.Address) int {
+	if weight, ok := address.BalancerAttributes.Value(weightKey{}).(uint32); ok && weight > 0 {
+		return int(weight)
+	}
+
+	return 1
+}
+
+type weightedPickerBuilder struct {
+	weights map[string]int // address -> weight of the latest resolver state
+}
+
+func (b *weightedPickerBuilder) updateAddresses(addresses []resolver.Address) {
+	b.weights = make(map[string]int, len(addresses))
+	for _, address := range addresses {
+		b.weights[address.Addr] = weightOf(address)
+	}
+}
+
+func (b *weightedPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+
+	picker := &weightedPicker{}
+	for subConn, subConnInfo := range info.ReadySCs {
+		weight, exist := b.weights[subConnInfo.Address.Addr]
+		if !exist {
+			weight = weightOf(subConnInfo.Address)
+		}
+		picker.items = append(picker.items, &weightedItem{subConn: subConn, weight: weight})
+	}
+
+	return picker
+}
+
+type weightedItem struct {
+	subConn balancer.SubConn
+	weight  int
+	current int
+}
+
+// weightedPicker is the smooth weighted round robin of nginx, weights 5,1,1 pick a,a,b,a,c,a,a
+type weightedPicker struct {
+	lock  sync.Mutex
+	items []*weightedItem
+}
+
+func (p *weightedPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var best *weightedItem
+	total := 0
+	for _, item := range p.items {
+		item.current += item.weight
+		total += item.weight
+		if best == nil || item.current > best.current {
+			best = item
+		}
+	}
+	best.current -= total
+
+	return balancer.PickResult{SubConn: best.subConn}, nil
+}
+
+// leastRequestPickerBuilder keeps the in-flight counters across pickers, a new picker is built on every
+// sub connection state change
+type leastRequestPickerBuilder struct {
+	inFlights map[balancer.SubConn]*int64
+}
+
+func (b *leastRequestPickerBuilder) updateAddresses([]resolver.Address) {}
+
+func (b *leastRequestPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	// counters of the SubConns not ready any more are dropped, the rpcs still running on them
+	// decrease the dropped counter
+	inFlights := make(map[balancer.SubConn]*int64, len(info.ReadySCs))
+	for subConn := range info.ReadySCs {
+		counter, exist := b.inFlights[subConn]
+		if !exist {
+			counter = new(int64)
+		}
+		inFlights[subConn] = counter
+	}
+	b.inFlights = inFlights
+
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+
+	picker := &leastRequestPicker{}
+	for subConn, counter := range inFlights {
+		picker.items = append(picker.items, leastRequestItem{subConn: subConn, inFlight: counter})
+	}
+
+	return picker
+}
+
+type leastRequestItem struct {
+	subConn  balancer.SubConn
+	inFlight *int64
+}
+
+// leastRequestPicker picks the less busy of two random instances, which avoids herding on the idlest one
+type leastRequestPicker struct {
+	items []leastRequestItem
+}
+
+func (p *leastRequestPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	index := rand.Intn(len(p.items))
+	item := p.items[index]
+	if len(p.items) > 1 {
+		// another instance than the first one, so an idle instance is never missed between two
+		otherIndex := rand.Intn(len(p.items) - 1)
+		if otherIndex >= index {
+			otherIndex++
+		}
+		if other := p.items[otherIndex]; atomic.LoadInt64(other.inFlight) < atomic.LoadInt64(item.inFlight) {
+			item = other
+		}
+	}
+
+	atomic.AddInt64(item.inFlight, 1)
+	return balancer.PickResult{
+		SubConn: item.subConn,
+		Done: func(balancer.DoneInfo) {
+			atomic.AddInt64(item.inFlight, -1)
+		},
+	}, nil
+}
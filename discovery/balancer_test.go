@@ -0,0 +1,178 @@
+package discovery
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/resolver"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testSubConn struct {
+	balancer.SubConn
+	name string
+}
+
+func weightedAddress(addr string, weight uint32) resolver.Address {
+	return resolver.Address{Addr: addr, BalancerAttributes: attributes.New(weightKey{}, weight)}
+}
+
+func pickNames(t *testing.T, picker balancer.Picker, count int) string {
+	names := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		result, err := picker.Pick(balancer.PickInfo{})
+		if err != nil {
+			t.Fatalf("Pick err:%v", err)
+		}
+		names = append(names, result.SubConn.(*testSubConn).name)
+		if result.Done != nil {
+			result.Done(balancer.DoneInfo{})
+		}
+	}
+
+	return strings.Join(names, ",")
+}
+
+func TestWeightedPicker(t *testing.T) {
+	picker := (&weightedPickerBuilder{}).Build(base.PickerBuildInfo{ReadySCs: map[balancer.SubConn]base.SubConnInfo{
+		&testSubConn{name: "a"}: {Address: weightedAddress("a", 5)},
+		&testSubConn{name: "b"}: {Address: weightedAddress("b", 1)},
+	}})
+
+	// smooth weighted round robin spreads the light instance among the heavy one
+	if got := pickNames(t, picker, 6); strings.Count(got, "a") != 5 || strings.HasPrefix(got, "b") || strings.HasSuffix(got, "b") {
+		t.Fatalf("got picks %s", got)
+	}
+
+	// the SubConns keep the addresses they were created with, the weights follow the resolver
+	builder := &weightedPickerBuilder{}
+	builder.updateAddresses([]resolver.Address{weightedAddress("a", 1), weightedAddress("b", 2)})
+	picker = builder.Build(base.PickerBuildInfo{ReadySCs: map[balancer.SubConn]base.SubConnInfo{
+		&testSubConn{name: "a"}: {Address: weightedAddress("a", 5)},
+		&testSubConn{name: "b"}: {Address: weightedAddress("b", 1)},
+	}})
+	if got := pickNames(t, picker, 6); strings.Count(got, "b") != 4 {
+		t.Fatalf("got picks %s after the weights changed", got)
+	}
+
+	picker = (&weightedPickerBuilder{}).Build(base.PickerBuildInfo{})
+	if _, err := picker.Pick(balancer.PickInfo{}); err != balancer.ErrNoSubConnAvailable {
+		t.Fatalf("no ready instance got err:%v", err)
+	}
+}
+
+func TestLeastRequestPicker(t *testing.T) {
+	a, b := &testSubConn{name: "a"}, &testSubConn{name: "b"}
+	readySCs := map[balancer.SubConn]base.SubConnInfo{a: {}, b: {}}
+	builder := &leastRequestPickerBuilder{inFlights: make(map[balancer.SubConn]*int64)}
+	picker := builder.Build(base.PickerBuildInfo{ReadySCs: readySCs})
+
+	// a keeps an rpc in flight
+	var busy balancer.PickResult
+	for busy.SubConn != a {
+		if busy.Done != nil {
+			busy.Done(balancer.DoneInfo{})
+		}
+		busy, _ = picker.Pick(balancer.PickInfo{})
+	}
+
+	// the counters outlive the picker, a new one is built on every state change
+	picker = builder.Build(base.PickerBuildInfo{ReadySCs: readySCs})
+	if got := pickNames(t, picker, 100); strings.Contains(got, "a") {
+		t.Fatalf("busy instance picked with an idle one ready: %s", got)
+	}
+
+	busy.Done(balancer.DoneInfo{})
+	if got := *builder.inFlights[a]; got != 0 {
+		t.Fatalf("in flight of a is %d after Done", got)
+	}
+
+	builder.Build(base.PickerBuildInfo{ReadySCs: map[balancer.SubConn]base.SubConnInfo{b: {}}})
+	if _, exist := builder.inFlights[a]; exist || len(builder.inFlights) != 1 {
+		t.Fatalf("counters of %d instances kept after a shut down", len(builder.inFlights))
+	}
+}
+
+type nameService struct {
+	testpb.UnimplementedTestServiceServer
+	name string
+}
+
+func (s *nameService) UnaryCall(ctx context.Context, req *testpb.SimpleRequest) (*testpb.SimpleResponse, error) {
+	return &testpb.SimpleResponse{Username: s.name}, nil
+}
+
+// serveNames serves a nameService per name and registers them, the health servers report them SERVING
+func serveNames(t *testing.T, registry Registry, names ...string) map[string]*health.Server {
+	healthServers := make(map[string]*health.Server)
+	for _, name := range names {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listen err:%v", err)
+		}
+		server := grpc.NewServer()
+		testpb.RegisterTestServiceServer(server, &nameService{name: name})
+		healthServers[name] = health.NewServer()
+		healthpb.RegisterHealthServer(server, healthServers[name])
+		go server.Serve(listener)
+		t.Cleanup(server.Stop)
+
+		_ = registry.Register(context.Background(), Instance{Service: "rpc.name", Address: listener.Addr().String()}, 0)
+	}
+
+	return healthServers
+}
+
+// callNames calls the instances until done is true for the calls counted by instance or a deadline passes
+func callNames(t *testing.T, registry Registry, healthCheck bool, done func(got map[string]int) bool) map[string]int {
+	conn, err := grpc.Dial(Target("rpc.name"), grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithResolvers(NewResolverBuilder(registry, healthCheck)))
+	if err != nil {
+		t.Fatalf("dial err:%v", err)
+	}
+	defer conn.Close()
+
+	client := testpb.NewTestServiceClient(conn)
+	got := make(map[string]int)
+	for deadline := time.Now().Add(2 * time.Second); !done(got) && time.Now().Before(deadline); {
+		resp, err := client.UnaryCall(context.Background(), &testpb.SimpleRequest{}, grpc.WaitForReady(true))
+		if err != nil {
+			t.Fatalf("call err:%v", err)
+		}
+		got[resp.GetUsername()]++
+	}
+
+	return got
+}
+
+func TestResolver(t *testing.T) {
+	registry := NewMemoryRegistry()
+	serveNames(t, registry, "a", "b")
+
+	// the instances get ready one by one, the first calls may all go to one of them
+	if got := callNames(t, registry, false, func(got map[string]int) bool { return got["a"] > 0 && got["b"] > 0 }); got["a"] == 0 || got["b"] == 0 {
+		t.Fatalf("got calls %v, want both instances used", got)
+	}
+}
+
+func TestResolverHealthCheck(t *testing.T) {
+	registry := NewMemoryRegistry()
+	healthServers := serveNames(t, registry, "a", "b")
+	healthServers["b"].SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+
+	if got := callNames(t, registry, true, func(got map[string]int) bool { return got["a"]+got["b"] >= 20 }); got["a"] != 20 {
+		t.Fatalf("got calls %v, want only the serving instance", got)
+	}
+	if got := callNames(t, registry, false, func(got map[string]int) bool { return got["b"] > 0 }); got["b"] == 0 {
+		t.Fatalf("got calls %v without health check, want both instances used", got)
+	}
+}
@@ -0,0 +1,125 @@
+package discovery
+
+import (
+	"encoding/xml"
+	"github.com/RealJonathanYip/framework/config"
+	"github.com/RealJonathanYip/framework/context0"
+	"github.com/RealJonathanYip/framework/log"
+	"github.com/fsnotify/fsnotify"
+	"path/filepath"
+)
+
+// file format:
+//
+//	<services>
+//		<service name="rpc.user">
+//			<instance address="10.0.0.1:8888" weight="10"/>
+//		</service>
+//	</services>
+type fileServices struct {
+	XMLName  xml.Name      `xml:"services"`
+	Services []fileService `xml:"service"`
+}
+
+type fileService struct {
+	Name      string         `xml:"name,attr"`
+	Instances []fileInstance `xml:"instance"`
+}
+
+type fileInstance struct {
+	Address  string         `xml:"address,attr"`
+	Weight   uint32         `xml:"weight,attr"`
+	Metadata []fileMetadata `xml:"metadata"`
+}
+
+type fileMetadata struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// FileRegistry serves the instances listed in an xml file, reloaded when the file changes,
+// instances registered at runtime are kept in memory beside them
+type FileRegistry struct {
+	*MemoryRegistry
+	file    string
+	watcher *fsnotify.Watcher
+}
+
+func NewFileRegistry(file string) (*FileRegistry, error) {
+	registry := &FileRegistry{MemoryRegistry: NewMemoryRegistry(), file: file}
+	if err := registry.load(); err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	// watch the directory, editors and config management replace the file instead of writing it
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		_ = watcher.Close()
+		return nil, err
+	}
+
+	registry.watcher = watcher
+	go registry.watch()
+
+	return registry, nil
+}
+
+// Close stops reloading the file
+func (f *FileRegistry) Close() error {
+	_ = f.MemoryRegistry.Close()
+	return f.watcher.Close()
+}
+
+func (f *FileRegistry) load() error {
+	content := &fileServices{}
+	if err := config.ReadXml(context0.NewContext(), f.file, content); err != nil {
+		return err
+	}
+
+	var instances []Instance
+	for _, service := range content.Services {
+		for _, item := range service.Instances {
+			instance := Instance{Service: service.Name, Address: item.Address, Weight: item.Weight}
+			if len(item.Metadata) > 0 {
+				instance.Metadata = make(map[string]string, len(item.Metadata))
+				for _, meta := range item.Metadata {
+					instance.Metadata[meta.Key] = meta.Value
+				}
+			}
+			instances = append(instances, instance)
+		}
+	}
+
+	f.replaceStatic(instances)
+	return nil
+}
+
+func (f *FileRegistry) watch() {
+	ctx := context0.NewContext()
+	for {
+		select {
+		case event, ok := <-f.watcher.Events:
+			if !ok {
+				return
+			}
+
+			if filepath.Clean(event.Name) != filepath.Clean(f.file) || event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+
+			// keep the last good instances when the new file is broken
+			if err := f.load(); err != nil {
+				log.Warningf(ctx, "reload discovery file:%s fail:%v", f.file, err)
+			}
+		case err, ok := <-f.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Warningf(ctx, "watch discovery file:%s err:%v", f.file, err)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package discovery
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+const _SWEEP_INTERVAL = time.Second
+
+type memoryEntry struct {
+	instance Instance
+	expireAt time.Time // zero for instances never expiring, e.g. loaded from file
+	static   bool
+}
+
+// MemoryRegistry keeps instances in the process, for tests and single host deployments
+type MemoryRegistry struct {
+	lock      sync.RWMutex
+	services  map[string]map[string]*memoryEntry // service -> address -> entry
+	watchers  map[string]map[chan []Instance]bool
+	sweepOnce sync.Once
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func NewMemoryRegistry() *MemoryRegistry {
+	return &MemoryRegistry{
+		services: make(map[string]map[string]*memoryEntry),
+		watchers: make(map[string]map[chan []Instance]bool),
+		closed:   make(chan struct{}),
+	}
+}
+
+// Close stops expiring the instances registered with a ttl
+func (m *MemoryRegistry) Close() error {
+	m.closeOnce.Do(func() {
+		close(m.closed)
+	})
+
+	return nil
+}
+
+// Register adds or refreshes an instance, it expires after ttl unless registered again, ttl <= 0 never expires
+func (m *MemoryRegistry) Register(ctx context.Context, instance Instance, ttl time.Duration) error {
+	entry := &memoryEntry{instance: instance}
+	if ttl > 0 {
+		entry.expireAt = time.Now().Add(ttl)
+		m.sweepOnce.Do(func() {
+			go m.sweep()
+		})
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	instances, exist := m.services[instance.Service]
+	if !exist {
+		instances = make(map[string]*memoryEntry)
+		m.services[instance.Service] = instances
+	}
+
+	old, exist := instances[instance.Address]
+	instances[instance.Address] = entry
+	if !exist || !sameInstance(old.instance, instance) {
+		m.notify(instance.Service)
+	}
+
+	return nil
+}
+
+func (m *MemoryRegistry) Deregister(ctx context.Context, instance Instance) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, exist := m.services[instance.Service][instance.Address]; exist {
+		delete(m.services[instance.Service], instance.Address)
+		m.notify(instance.Service)
+	}
+
+	return nil
+}
+
+func (m *MemoryRegistry) Resolve(ctx context.Context, service string) ([]Instance, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.instances(service), nil
+}
+
+func (m *MemoryRegistry) Watch(ctx context.Context, service string) (<-chan []Instance, error) {
+	// the latest list replaces the one not received yet, so a slow watcher never blocks the registry
+	watcher := make(chan []Instance, 1)
+
+	m.lock.Lock()
+	if _, exist := m.watchers[service]; !exist {
+		m.watchers[service] = make(map[chan []Instance]bool)
+	}
+	m.watchers[service][watcher] = true
+	watcher <- m.instances(service)
+	m.lock.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		m.lock.Lock()
+		defer m.lock.Unlock()
+
+		delete(m.watchers[service], watcher)
+		close(watcher)
+	}()
+
+	return watcher, nil
+}
+
+// replaceStatic swaps all the never expiring instances loaded from a file
+func (m *MemoryRegistry) replaceStatic(instances []Instance) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	changed := make(map[string]bool)
+	for service, entries := range m.services {
+		for address, entry := range entries {
+			if entry.static {
+				delete(entries, address)
+				changed[service] = true
+			}
+		}
+	}
+
+	for _, instance := range instances {
+		if _, exist := m.services[instance.Service]; !exist {
+			m.services[instance.Service] = make(map[string]*memoryEntry)
+		}
+		m.services[instance.Service][instance.Address] = &memoryEntry{instance: instance, static: true}
+		changed[instance.Service] = true
+	}
+
+	for service := range changed {
+		m.notify(service)
+	}
+}
+
+func (m *MemoryRegistry) sweep() {
+	ticker := time.NewTicker(_SWEEP_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		var now time.Time
+		select {
+		case <-m.closed:
+			return
+		case now = <-ticker.C:
+		}
+
+		m.lock.Lock()
+		for service, entries := range m.services {
+			expired := false
+			for address, entry := range entries {
+				if !entry.expireAt.IsZero() && now.After(entry.expireAt) {
+					delete(entries, address)
+					expired = true
+				}
+			}
+
+			if expired {
+				m.notify(service)
+			}
+		}
+		m.lock.Unlock()
+	}
+}
+
+// instances must be called with lock held, sorted by address so watchers can compare lists
+func (m *MemoryRegistry) instances(service string) []Instance {
+	now := time.Now()
+	instances := make([]Instance, 0, len(m.services[service]))
+	for _, entry := range m.services[service] {
+		if entry.expireAt.IsZero() || now.Before(entry.expireAt) {
+			instances = append(instances, entry.instance)
+		}
+	}
+
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].Address < instances[j].Address
+	})
+
+	return instances
+}
+
+// notify must be called with lock held
+func (m *MemoryRegistry) notify(service string) {
+	instances := m.instances(service)
+	for watcher := range m.watchers[service] {
+		select {
+		case <-watcher:
+		default:
+		}
+		watcher <- instances
+	}
+}
+
+func sameInstance(a, b Instance) bool {
+	if a.Weight != b.Weight || len(a.Metadata) != len(b.Metadata) {
+		return false
+	}
+
+	for key, value := range a.Metadata {
+		if b.Metadata[key] != value {
+			return false
+		}
+	}
+
+	return true
+}
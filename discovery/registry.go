@@ -0,0 +1,131 @@
+package discovery
+
+import (
+	"context"
+	"github.com/RealJonathanYip/framework/log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DEFAULT_TTL is the ttl used by the servers to register themselves, refreshed every ttl/3
+const DEFAULT_TTL = 10 * time.Second
+
+type Instance struct {
+	Service  string            `json:"service"`
+	Address  string            `json:"address"`
+	Weight   uint32            `json:"weight"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Registry keeps the instances of every service, Watch sends the whole instance list on every change
+// and closes the channel when ctx is done
+type Registry interface {
+	Register(ctx context.Context, instance Instance, ttl time.Duration) error
+	Deregister(ctx context.Context, instance Instance) error
+	Resolve(ctx context.Context, service string) ([]Instance, error)
+	Watch(ctx context.Context, service string) (<-chan []Instance, error)
+}
+
+var (
+	defaultRegistry     Registry = NewMemoryRegistry()
+	defaultRegistryLock sync.RWMutex
+)
+
+// Default is the registry used by HttpServer, RpcServer and GetRpcServiceConnection, in-memory by default
+func Default() Registry {
+	defaultRegistryLock.RLock()
+	defer defaultRegistryLock.RUnlock()
+
+	return defaultRegistry
+}
+
+// SetDefault replaces the default registry, it must be called before the servers start
+func SetDefault(registry Registry) {
+	defaultRegistryLock.Lock()
+	defer defaultRegistryLock.Unlock()
+
+	defaultRegistry = registry
+}
+
+// Heartbeat keeps an instance registered by refreshing its ttl until Stop
+type Heartbeat struct {
+	registry Registry
+	instance Instance
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// StartHeartbeat registers instance at once then every ttl/3, failures are logged and retried on the next beat,
+// ttl <= 0 registers once without expiring
+func StartHeartbeat(ctx context.Context, registry Registry, instance Instance, ttl time.Duration) (*Heartbeat, error) {
+	if err := registry.Register(ctx, instance, ttl); err != nil {
+		return nil, err
+	}
+
+	ctxHeartbeat, cancel := context.WithCancel(ctx)
+	heartbeat := &Heartbeat{registry: registry, instance: instance, cancel: cancel, done: make(chan struct{})}
+	if ttl <= 0 {
+		close(heartbeat.done)
+		return heartbeat, nil
+	}
+
+	go func() {
+		defer close(heartbeat.done)
+
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctxHeartbeat.Done():
+				return
+			case <-ticker.C:
+				if err := registry.Register(ctxHeartbeat, instance, ttl); err != nil {
+					log.Warningf(ctxHeartbeat, "heartbeat %s@%s fail:%v", instance.Service, instance.Address, err)
+				}
+			}
+		}
+	}()
+
+	return heartbeat, nil
+}
+
+// Stop ends the heartbeat and deregisters the instance
+func (h *Heartbeat) Stop(ctx context.Context) error {
+	h.cancel()
+	<-h.done
+
+	return h.registry.Deregister(ctx, h.instance)
+}
+
+// AdvertiseAddress turns a bound address into one reachable by other hosts, e.g. [::]:8888 -> 10.0.0.3:8888
+func AdvertiseAddress(addr net.Addr) string {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return addr.String()
+	}
+
+	if tcpAddr.IP != nil && !tcpAddr.IP.IsUnspecified() {
+		return tcpAddr.String()
+	}
+
+	return net.JoinHostPort(LocalIP(), strconv.Itoa(tcpAddr.Port))
+}
+
+// LocalIP returns the first non loopback ipv4 address, 127.0.0.1 if there is none
+func LocalIP() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "127.0.0.1"
+	}
+
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+			return ipNet.IP.String()
+		}
+	}
+
+	return "127.0.0.1"
+}
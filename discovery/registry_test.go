@@ -0,0 +1,156 @@
+package discovery
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMemoryRegistry(t *testing.T) {
+	registry := NewMemoryRegistry()
+	ctx := context.Background()
+
+	_ = registry.Register(ctx, Instance{Service: "rpc.user", Address: "10.0.0.2:8888"}, 0)
+	_ = registry.Register(ctx, Instance{Service: "rpc.user", Address: "10.0.0.1:8888", Weight: 5}, 0)
+	_ = registry.Register(ctx, Instance{Service: "rpc.user", Address: "10.0.0.3:8888"}, 10*time.Millisecond)
+
+	instances, _ := registry.Resolve(ctx, "rpc.user")
+	if len(instances) != 3 || instances[0].Address != "10.0.0.1:8888" || instances[0].Weight != 5 {
+		t.Fatalf("got %+v, want 3 instances sorted by address", instances)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if instances, _ = registry.Resolve(ctx, "rpc.user"); len(instances) != 2 {
+		t.Fatalf("got %+v after the ttl passed", instances)
+	}
+
+	_ = registry.Deregister(ctx, Instance{Service: "rpc.user", Address: "10.0.0.2:8888"})
+	if instances, _ = registry.Resolve(ctx, "rpc.user"); len(instances) != 1 || instances[0].Address != "10.0.0.1:8888" {
+		t.Fatalf("got %+v after Deregister", instances)
+	}
+}
+
+func TestMemoryRegistryWatch(t *testing.T) {
+	registry := NewMemoryRegistry()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	watcher, _ := registry.Watch(ctx, "rpc.user")
+	if instances := <-watcher; len(instances) != 0 {
+		t.Fatalf("first list got %+v", instances)
+	}
+
+	instance := Instance{Service: "rpc.user", Address: "10.0.0.1:8888"}
+	_ = registry.Register(ctx, instance, 0)
+	if instances := <-watcher; len(instances) != 1 {
+		t.Fatalf("after Register got %+v", instances)
+	}
+
+	// refreshing an unchanged instance, e.g. by a heartbeat, sends nothing
+	_ = registry.Register(ctx, instance, 0)
+	select {
+	case instances := <-watcher:
+		t.Fatalf("refresh sent %+v", instances)
+	default:
+	}
+
+	cancel()
+	for range watcher {
+	}
+}
+
+func TestFileRegistry(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "services.xml")
+	write := func(content string) {
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`<services><service name="rpc.user"><instance address="10.0.0.1:8888" weight="10"><metadata key="zone">a</metadata></instance></service></services>`)
+
+	registry, err := NewFileRegistry(file)
+	if err != nil {
+		t.Fatalf("NewFileRegistry err:%v", err)
+	}
+	defer registry.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	watcher, _ := registry.Watch(ctx, "rpc.user")
+	if instances := <-watcher; len(instances) != 1 || instances[0].Weight != 10 || instances[0].Metadata["zone"] != "a" {
+		t.Fatalf("loaded %+v", instances)
+	}
+
+	write(`<services><service name="rpc.user"><instance address="10.0.0.2:8888"/></service></services>`)
+	select {
+	case instances := <-watcher:
+		if len(instances) != 1 || instances[0].Address != "10.0.0.2:8888" {
+			t.Fatalf("reloaded %+v", instances)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("file change not reloaded")
+	}
+}
+
+func TestStartHeartbeat(t *testing.T) {
+	registry := NewMemoryRegistry()
+	ctx := context.Background()
+	instance := Instance{Service: "rpc.test", Address: "127.0.0.1:8888"}
+
+	heartbeat, err := StartHeartbeat(ctx, registry, instance, 30*time.Millisecond)
+	if err != nil {
+		t.Fatalf("StartHeartbeat err:%v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if instances, _ := registry.Resolve(ctx, instance.Service); len(instances) != 1 {
+		t.Fatalf("got %d instances while beating, want 1", len(instances))
+	}
+
+	if err := heartbeat.Stop(ctx); err != nil {
+		t.Fatalf("Stop err:%v", err)
+	}
+	if instances, _ := registry.Resolve(ctx, instance.Service); len(instances) != 0 {
+		t.Fatalf("got %d instances after Stop, want 0", len(instances))
+	}
+}
+
+func TestStartHeartbeatWithoutTtl(t *testing.T) {
+	registry := NewMemoryRegistry()
+	ctx := context.Background()
+
+	for _, ttl := range []time.Duration{0, -time.Second} {
+		instance := Instance{Service: "rpc.test", Address: "127.0.0.1:8888"}
+		heartbeat, err := StartHeartbeat(ctx, registry, instance, ttl)
+		if err != nil {
+			t.Fatalf("ttl %v StartHeartbeat err:%v", ttl, err)
+		}
+		if instances, _ := registry.Resolve(ctx, instance.Service); len(instances) != 1 {
+			t.Fatalf("ttl %v got %d instances, want 1", ttl, len(instances))
+		}
+
+		if err := heartbeat.Stop(ctx); err != nil {
+			t.Fatalf("ttl %v Stop err:%v", ttl, err)
+		}
+		if instances, _ := registry.Resolve(ctx, instance.Service); len(instances) != 0 {
+			t.Fatalf("ttl %v got %d instances after Stop", ttl, len(instances))
+		}
+	}
+}
+
+func TestMemoryRegistryClose(t *testing.T) {
+	registry := NewMemoryRegistry()
+	if err := registry.Register(context.Background(), Instance{Service: "rpc.test", Address: "127.0.0.1:8888"}, time.Millisecond); err != nil {
+		t.Fatalf("Register err:%v", err)
+	}
+
+	// closing twice must not panic
+	if err := registry.Close(); err != nil {
+		t.Fatalf("Close err:%v", err)
+	}
+	if err := registry.Close(); err != nil {
+		t.Fatalf("Close again err:%v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"github.com/RealJonathanYip/framework/context0"
+	"github.com/RealJonathanYip/framework/log"
+	"google.golang.org/grpc/attributes"
+	_ "google.golang.org/grpc/health" // client side health checking
+	"google.golang.org/grpc/resolver"
+	"strings"
+	"sync"
+)
+
+// SCHEME is the grpc target scheme resolved by the registry, e.g. discovery:///rpc.user
+const SCHEME = "discovery"
+
+type weightKey struct{}
+
+var (
+	balancers     = make(map[string]string)
+	balancersLock sync.RWMutex
+)
+
+// SetBalancer selects the load balancing policy of a service, BALANCER_ROUND_ROBIN by default,
+// connections already dialed switch on the next instance change
+func SetBalancer(service, policy string) {
+	balancersLock.Lock()
+	defer balancersLock.Unlock()
+
+	balancers[service] = policy
+}
+
+func balancerOf(service string) string {
+	balancersLock.RLock()
+	defer balancersLock.RUnlock()
+
+	if policy, exist := balancers[service]; exist {
+		return policy
+	}
+
+	return BALANCER_ROUND_ROBIN
+}
+
+// Target is the dial target of service for the discovery resolver
+func Target(service string) string {
+	return SCHEME + ":///" + service
+}
+
+type resolverBuilder struct {
+	registry    Registry
+	healthCheck bool
+}
+
+// NewResolverBuilder resolves discovery targets from registry, discovery.Default() if nil,
+// pass it to grpc.WithResolvers. With healthCheck rpcs only go to instances whose grpc.health.v1 status is SERVING,
+// it must be in the service config sent by the resolver because that one replaces grpc.WithDefaultServiceConfig
+func NewResolverBuilder(registry Registry, healthCheck bool) resolver.Builder {
+	return &resolverBuilder{registry: registry, healthCheck: healthCheck}
+}
+
+func (b *resolverBuilder) Scheme() string {
+	return SCHEME
+}
+
+func (b *resolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	registry := b.registry
+	if registry == nil {
+		registry = Default()
+	}
+
+	service := strings.TrimPrefix(target.URL.Path, "/")
+	if service == "" {
+		service = target.URL.Opaque
+	}
+
+	ctx, cancel := context.WithCancel(context0.NewContext())
+	watcher, err := registry.Watch(ctx, service)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	r := &discoveryResolver{service: service, cc: cc, cancel: cancel, healthCheck: b.healthCheck}
+	go r.watch(ctx, watcher)
+
+	return r, nil
+}
+
+type discoveryResolver struct {
+	service     string
+	cc          resolver.ClientConn
+	cancel      context.CancelFunc
+	healthCheck bool
+}
+
+func (r *discoveryResolver) watch(ctx context.Context, watcher <-chan []Instance) {
+	for instances := range watcher {
+		addresses := make([]resolver.Address, 0, len(instances))
+		for _, instance := range instances {
+			weight := instance.Weight
+			if weight == 0 {
+				weight = 1
+			}
+
+			addresses = append(addresses, resolver.Address{
+				Addr:               instance.Address,
+				BalancerAttributes: attributes.New(weightKey{}, weight),
+			})
+		}
+
+		serviceConfig := r.cc.ParseServiceConfig(r.serviceConfig())
+		if err := r.cc.UpdateState(resolver.State{Addresses: addresses, ServiceConfig: serviceConfig}); err != nil {
+			log.Warningf(ctx, "update %s instances:%+v err:%v", r.service, instances, err)
+		}
+	}
+}
+
+func (r *discoveryResolver) serviceConfig() string {
+	if r.healthCheck {
+		return fmt.Sprintf(`{"loadBalancingConfig":[{"%s":{}}],"healthCheckConfig":{"serviceName":""}}`, balancerOf(r.service))
+	}
+
+	return fmt.Sprintf(`{"loadBalancingConfig":[{"%s":{}}]}`, balancerOf(r.service))
+}
+
+// ResolveNow does nothing, instances are pushed by the registry
+func (r *discoveryResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+func (r *discoveryResolver) Close() {
+	r.cancel()
+}
@@ -5,6 +5,13 @@ import (
 	"github.com/RealJonathanYip/framework/config"
 	"github.com/RealJonathanYip/framework/context0"
 	"github.com/RealJonathanYip/framework/log"
+	"strings"
+)
+
+const (
+	ENV_TEST       = "test"
+	ENV_DEV        = "dev"
+	ENV_PRODUCTION = "production"
 )
 
 type logOutput struct {
@@ -38,3 +45,9 @@ func Init(configFile string) {
 func Env() string {
 	return frameWorkConfig.Env
 }
+
+// IsTestEnv reports whether env of the config file is test or dev, debug features default to on there
+func IsTestEnv() bool {
+	env := strings.ToLower(frameWorkConfig.Env)
+	return env == ENV_TEST || env == ENV_DEV
+}
@@ -0,0 +1,18 @@
+package framework
+
+import (
+	"testing"
+)
+
+func TestIsTestEnv(t *testing.T) {
+	defer func(env string) {
+		frameWorkConfig.Env = env
+	}(frameWorkConfig.Env)
+
+	for env, want := range map[string]bool{"test": true, "Dev": true, "production": false, "": false} {
+		frameWorkConfig.Env = env
+		if got := IsTestEnv(); got != want {
+			t.Errorf("env %q got %v", env, got)
+		}
+	}
+}
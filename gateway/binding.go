@@ -0,0 +1,189 @@
+package gateway
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var (
+	unmarshalOptions = protojson.UnmarshalOptions{DiscardUnknown: true}
+	marshalOptions   = protojson.MarshalOptions{EmitUnpopulated: true}
+)
+
+func findField(desc protoreflect.MessageDescriptor, name string) protoreflect.FieldDescriptor {
+	if fd := desc.Fields().ByName(protoreflect.Name(name)); fd != nil {
+		return fd
+	}
+
+	return desc.Fields().ByJSONName(name)
+}
+
+// bindBody fills the request from the json body, body is "*" or the name of a request field
+func bindBody(msg proto.Message, body string, data []byte) error {
+	if len(data) == 0 || body == "" {
+		return nil
+	}
+
+	if body == "*" {
+		return unmarshalOptions.Unmarshal(data, msg)
+	}
+
+	fd := findField(msg.ProtoReflect().Descriptor(), body)
+	if fd == nil {
+		return errors.Errorf("body field:%s not found in %s", body, msg.ProtoReflect().Descriptor().FullName())
+	}
+
+	wrapped, err := json.Marshal(map[string]json.RawMessage{fd.JSONName(): data})
+	if err != nil {
+		return err
+	}
+
+	return unmarshalOptions.Unmarshal(wrapped, msg)
+}
+
+// bindQuery fills fields not bound by path or body from the query string, unknown keys are ignored
+func bindQuery(msg proto.Message, query url.Values, bound map[string]bool) error {
+	for key, values := range query {
+		if bound[key] {
+			continue
+		}
+
+		for _, value := range values {
+			if err := setField(msg.ProtoReflect(), key, value); err != nil && !errors.Is(err, errFieldNotFound) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+var errFieldNotFound = errors.New("field not found")
+
+// setField sets a dotted field path, repeated fields get value appended
+func setField(msg protoreflect.Message, fieldPath string, value string) error {
+	names := strings.Split(fieldPath, ".")
+	for _, name := range names[:len(names)-1] {
+		fd := findField(msg.Descriptor(), name)
+		if fd == nil {
+			return errors.Wrap(errFieldNotFound, fieldPath)
+		}
+		if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+			return errors.Errorf("field:%s of %s is not a message", name, fieldPath)
+		}
+		msg = msg.Mutable(fd).Message()
+	}
+
+	fd := findField(msg.Descriptor(), names[len(names)-1])
+	if fd == nil {
+		return errors.Wrap(errFieldNotFound, fieldPath)
+	}
+	if fd.IsMap() {
+		return errors.Errorf("map field:%s can not be bound from string", fieldPath)
+	}
+
+	fieldValue, err := parseValue(msg, fd, value)
+	if err != nil {
+		return errors.Wrapf(err, "parse field:%s", fieldPath)
+	}
+
+	if fd.IsList() {
+		msg.Mutable(fd).List().Append(fieldValue)
+	} else {
+		msg.Set(fd, fieldValue)
+	}
+
+	return nil
+}
+
+func parseValue(msg protoreflect.Message, fd protoreflect.FieldDescriptor, value string) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(value), nil
+	case protoreflect.BoolKind:
+		v, err := strconv.ParseBool(value)
+		return protoreflect.ValueOfBool(v), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		v, err := strconv.ParseInt(value, 10, 32)
+		return protoreflect.ValueOfInt32(int32(v)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		v, err := strconv.ParseInt(value, 10, 64)
+		return protoreflect.ValueOfInt64(v), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		v, err := strconv.ParseUint(value, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(v)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		v, err := strconv.ParseUint(value, 10, 64)
+		return protoreflect.ValueOfUint64(v), err
+	case protoreflect.FloatKind:
+		v, err := strconv.ParseFloat(value, 32)
+		return protoreflect.ValueOfFloat32(float32(v)), err
+	case protoreflect.DoubleKind:
+		v, err := strconv.ParseFloat(value, 64)
+		return protoreflect.ValueOfFloat64(v), err
+	case protoreflect.BytesKind:
+		v, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			v, err = base64.URLEncoding.DecodeString(value)
+		}
+		return protoreflect.ValueOfBytes(v), err
+	case protoreflect.EnumKind:
+		if enumValue := fd.Enum().Values().ByName(protoreflect.Name(value)); enumValue != nil {
+			return protoreflect.ValueOfEnum(enumValue.Number()), nil
+		}
+		v, err := strconv.ParseInt(value, 10, 32)
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(v)), err
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		// well known types such as Timestamp, Duration and wrappers accept their json string form
+		fieldValue := msg.NewField(fd)
+		if fd.IsList() {
+			fieldValue = protoreflect.ValueOfMessage(fieldValue.List().NewElement().Message())
+		}
+		target := fieldValue.Message().Interface()
+		if err := unmarshalOptions.Unmarshal([]byte(strconv.Quote(value)), target); err != nil {
+			if err := unmarshalOptions.Unmarshal([]byte(value), target); err != nil {
+				return protoreflect.Value{}, err
+			}
+		}
+		return fieldValue, nil
+	}
+
+	return protoreflect.Value{}, errors.Errorf("unsupported kind %v", fd.Kind())
+}
+
+// marshalResponse marshals the whole response or only responseBody field of it
+func marshalResponse(msg proto.Message, responseBody string) ([]byte, error) {
+	if responseBody == "" {
+		return marshalOptions.Marshal(msg)
+	}
+
+	fd := findField(msg.ProtoReflect().Descriptor(), responseBody)
+	if fd == nil {
+		return nil, errors.Errorf("response body field:%s not found in %s", responseBody, msg.ProtoReflect().Descriptor().FullName())
+	}
+
+	if fd.Message() != nil && !fd.IsList() && !fd.IsMap() {
+		return marshalOptions.Marshal(msg.ProtoReflect().Get(fd).Message().Interface())
+	}
+
+	partial := msg.ProtoReflect().New()
+	partial.Set(fd, msg.ProtoReflect().Get(fd))
+	data, err := marshalOptions.Marshal(partial.Interface())
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	return fields[fd.JSONName()], nil
+}
@@ -0,0 +1,360 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"github.com/RealJonathanYip/framework/context0"
+	"github.com/RealJonathanYip/framework/http_server"
+	"github.com/RealJonathanYip/framework/log"
+	"github.com/RealJonathanYip/framework/rpc_server"
+	"github.com/pkg/errors"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Gateway registers http routes on a HttpServer which transcode json to grpc methods
+type Gateway struct {
+	httpServer *http_server.HttpServer
+	invoke     func(ctx context.Context, fullMethod string, req proto.Message, output protoreflect.MessageDescriptor) (proto.Message, error)
+	routers    map[string]*router
+	lock       sync.RWMutex
+	maxBody    int64
+}
+
+type gatewayOption interface {
+	apply(*Gateway)
+}
+
+type gatewayOptionFunc func(*Gateway)
+
+func (f gatewayOptionFunc) apply(gateway *Gateway) {
+	f(gateway)
+}
+
+// MaxBody rejects request bodies larger than size bytes with 413, default 4MB like grpc
+func MaxBody(size int) gatewayOption {
+	return gatewayOptionFunc(func(gateway *Gateway) {
+		gateway.maxBody = int64(size)
+	})
+}
+
+type route struct {
+	template     *pathTemplate
+	method       protoreflect.MethodDescriptor
+	fullMethod   string
+	body         string
+	responseBody string
+}
+
+// router dispatches the templates sharing one http method and literal prefix
+type router struct {
+	routes []*route
+}
+
+// New creates a gateway calling the services registered on rpcServer in-process,
+// the call still runs the server interceptor chain
+func New(httpServer *http_server.HttpServer, rpcServer *rpc_server.RpcServer, opts ...gatewayOption) *Gateway {
+	gateway := newGateway(httpServer, opts...)
+	gateway.invoke = func(ctx context.Context, fullMethod string, req proto.Message, output protoreflect.MessageDescriptor) (proto.Message, error) {
+		resp, err := rpcServer.Invoke(ctx, fullMethod, func(in interface{}) error {
+			msg, ok := in.(proto.Message)
+			if !ok {
+				return status.Errorf(codes.Internal, "request of %s is not a proto message", fullMethod)
+			}
+
+			proto.Merge(msg, req)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		msg, ok := resp.(proto.Message)
+		if !ok {
+			return nil, status.Errorf(codes.Internal, "response of %s is not a proto message", fullMethod)
+		}
+
+		return msg, nil
+	}
+
+	return gateway
+}
+
+// NewWithConn creates a gateway calling remote services over conn,
+// conn should be dialed with interceptor.WithClientUnaryInterceptor (e.g. from GetRpcServiceConnection)
+func NewWithConn(httpServer *http_server.HttpServer, conn *grpc.ClientConn, opts ...gatewayOption) *Gateway {
+	gateway := newGateway(httpServer, opts...)
+	gateway.invoke = func(ctx context.Context, fullMethod string, req proto.Message, output protoreflect.MessageDescriptor) (proto.Message, error) {
+		resp := newMessage(output)
+		if err := conn.Invoke(ctx, fullMethod, req, resp); err != nil {
+			return nil, err
+		}
+
+		return resp, nil
+	}
+
+	return gateway
+}
+
+func newGateway(httpServer *http_server.HttpServer, opts ...gatewayOption) *Gateway {
+	gateway := &Gateway{
+		httpServer: httpServer,
+		routers:    make(map[string]*router),
+		maxBody:    4 << 20,
+	}
+	for _, opt := range opts {
+		opt.apply(gateway)
+	}
+
+	return gateway
+}
+
+// RegisterService registers the google.api.http annotated methods of a service, e.g. "helloworld.Greeter",
+// the generated go package of the service must be imported so its descriptor is registered
+func (g *Gateway) RegisterService(serviceName string) error {
+	service, err := findService(serviceName)
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	methods := service.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		method := methods.Get(i)
+		if method.IsStreamingClient() || method.IsStreamingServer() {
+			continue
+		}
+
+		options := method.Options()
+		if options == nil || !proto.HasExtension(options, annotations.E_Http) {
+			continue
+		}
+
+		rule, ok := proto.GetExtension(options, annotations.E_Http).(*annotations.HttpRule)
+		if !ok || rule == nil {
+			continue
+		}
+
+		for _, binding := range append([]*annotations.HttpRule{rule}, rule.GetAdditionalBindings()...) {
+			httpMethod, pathTemplate := ruleMethodAndPath(binding)
+			if httpMethod == "" {
+				continue
+			}
+
+			if err := g.handle(httpMethod, pathTemplate, method, binding.GetBody(), binding.GetResponseBody()); err != nil {
+				return err
+			}
+			count++
+		}
+	}
+
+	if count == 0 {
+		return errors.Errorf("service:%s has no google.api.http annotated unary method", serviceName)
+	}
+
+	return nil
+}
+
+// Handle maps an http route to a grpc method explicitly,
+// fullMethod is like "/helloworld.Greeter/SayHello" and body is "*", a request field name or "" for no body
+func (g *Gateway) Handle(httpMethod, pathTemplate, fullMethod, body string) error {
+	methodInfos := strings.Split(fullMethod, "/")
+	if len(methodInfos) != 3 {
+		return errors.Errorf("malformed method name: %s", fullMethod)
+	}
+
+	service, err := findService(methodInfos[1])
+	if err != nil {
+		return err
+	}
+
+	method := service.Methods().ByName(protoreflect.Name(methodInfos[2]))
+	if method == nil {
+		return errors.Errorf("method:%s not found in service:%s", methodInfos[2], methodInfos[1])
+	}
+
+	return g.handle(strings.ToUpper(httpMethod), pathTemplate, method, body, "")
+}
+
+func (g *Gateway) handle(httpMethod, pathTemplate string, method protoreflect.MethodDescriptor, body, responseBody string) error {
+	template, err := parseTemplate(pathTemplate)
+	if err != nil {
+		return err
+	}
+
+	routeTemp := &route{
+		template:     template,
+		method:       method,
+		fullMethod:   fmt.Sprintf("/%s/%s", method.Parent().FullName(), method.Name()),
+		body:         body,
+		responseBody: responseBody,
+	}
+
+	prefix, exact := template.literalPrefix()
+	if exact {
+		g.httpServer.Handle(httpMethod, prefix, func(ctx context.Context, resp *http_server.Response, req *http_server.Request) {
+			g.serve(ctx, resp, req, routeTemp, map[string]string{})
+		}, nil)
+		return nil
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	key := httpMethod + " " + prefix
+	routerTemp, exist := g.routers[key]
+	if !exist {
+		routerTemp = &router{}
+		g.routers[key] = routerTemp
+		g.httpServer.HandlePrefix(httpMethod, prefix, func(ctx context.Context, resp *http_server.Response, req *http_server.Request) {
+			g.dispatch(ctx, resp, req, routerTemp)
+		}, nil)
+	}
+	routerTemp.routes = append(routerTemp.routes, routeTemp)
+
+	log.Infof(context0.NewContext(), "register gateway route %s %s -> %s", httpMethod, pathTemplate, routeTemp.fullMethod)
+	return nil
+}
+
+func (g *Gateway) dispatch(ctx context.Context, resp *http_server.Response, req *http_server.Request, routerTemp *router) {
+	g.lock.RLock()
+	routes := routerTemp.routes
+	g.lock.RUnlock()
+
+	for _, routeTemp := range routes {
+		if values, ok := routeTemp.template.match(req.URL.Path); ok {
+			g.serve(ctx, resp, req, routeTemp, values)
+			return
+		}
+	}
+
+	g.replyError(ctx, resp, status.Errorf(codes.NotFound, "no route for %s %s", req.Method, req.URL.Path))
+}
+
+func (g *Gateway) serve(ctx context.Context, resp *http_server.Response, req *http_server.Request, routeTemp *route, pathValues map[string]string) {
+	request := newMessage(routeTemp.method.Input())
+
+	if routeTemp.body != "" {
+		data, err := io.ReadAll(http.MaxBytesReader(resp, req.Body, g.maxBody))
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				_ = resp.ReplyJsonWithStatus(ctx, http.StatusRequestEntityTooLarge, &http_server.Reply{
+					Result: http_server.HttpResult(codes.InvalidArgument),
+					Msg:    fmt.Sprintf("request body larger than %d bytes", g.maxBody),
+				})
+				return
+			}
+
+			g.replyError(ctx, resp, status.Errorf(codes.InvalidArgument, "read body fail: %v", err))
+			return
+		}
+
+		if err := bindBody(request, routeTemp.body, data); err != nil {
+			g.replyError(ctx, resp, status.Errorf(codes.InvalidArgument, "%v", err))
+			return
+		}
+	}
+
+	bound := map[string]bool{}
+	for fieldPath, value := range pathValues {
+		if err := setField(request.ProtoReflect(), fieldPath, value); err != nil {
+			g.replyError(ctx, resp, status.Errorf(codes.InvalidArgument, "%v", err))
+			return
+		}
+		bound[fieldPath] = true
+	}
+
+	if routeTemp.body != "*" {
+		if routeTemp.body != "" {
+			bound[routeTemp.body] = true
+		}
+
+		if err := bindQuery(request, req.URL.Query(), bound); err != nil {
+			g.replyError(ctx, resp, status.Errorf(codes.InvalidArgument, "%v", err))
+			return
+		}
+	}
+
+	now := time.Now()
+	response, err := g.invoke(ctx, routeTemp.fullMethod, request, routeTemp.method.Output())
+	log.Debugf(ctx, "gateway %s %s -> %s cost:%v(ms) err:%v", req.Method, req.URL.Path, routeTemp.fullMethod,
+		time.Since(now).Milliseconds(), err)
+	if err != nil {
+		g.replyError(ctx, resp, err)
+		return
+	}
+
+	data, err := marshalResponse(response, routeTemp.responseBody)
+	if err != nil {
+		g.replyError(ctx, resp, status.Errorf(codes.Internal, "marshal response fail: %v", err))
+		return
+	}
+
+	_ = resp.ReplyJson(ctx, data)
+}
+
+func (g *Gateway) replyError(ctx context.Context, resp *http_server.Response, err error) {
+	st := status.Convert(err)
+	httpStatus := HttpStatusFromCode(st.Code())
+	if httpStatus >= http.StatusInternalServerError {
+		log.Warningf(ctx, "gateway call fail: %v", err)
+	}
+
+	_ = resp.ReplyJsonWithStatus(ctx, httpStatus, &http_server.Reply{
+		Result: http_server.HttpResult(st.Code()),
+		Msg:    st.Message(),
+	})
+}
+
+func findService(serviceName string) (protoreflect.ServiceDescriptor, error) {
+	desc, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(serviceName))
+	if err != nil {
+		return nil, errors.Wrapf(err, "find service:%s", serviceName)
+	}
+
+	service, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, errors.Errorf("%s is not a service", serviceName)
+	}
+
+	return service, nil
+}
+
+func newMessage(desc protoreflect.MessageDescriptor) proto.Message {
+	if messageType, err := protoregistry.GlobalTypes.FindMessageByName(desc.FullName()); err == nil {
+		return messageType.New().Interface()
+	}
+
+	return dynamicpb.NewMessage(desc)
+}
+
+func ruleMethodAndPath(rule *annotations.HttpRule) (string, string) {
+	switch pattern := rule.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		return http.MethodGet, pattern.Get
+	case *annotations.HttpRule_Post:
+		return http.MethodPost, pattern.Post
+	case *annotations.HttpRule_Put:
+		return http.MethodPut, pattern.Put
+	case *annotations.HttpRule_Delete:
+		return http.MethodDelete, pattern.Delete
+	case *annotations.HttpRule_Patch:
+		return http.MethodPatch, pattern.Patch
+	case *annotations.HttpRule_Custom:
+		return strings.ToUpper(pattern.Custom.GetKind()), pattern.Custom.GetPath()
+	}
+
+	return "", ""
+}
@@ -0,0 +1,204 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/RealJonathanYip/framework/context0"
+	"github.com/RealJonathanYip/framework/http_server"
+	"github.com/RealJonathanYip/framework/rpc_server"
+	"google.golang.org/grpc/codes"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type testService struct {
+	testpb.UnimplementedTestServiceServer
+}
+
+// UnaryCall echoes the bound fields back, a non zero response_status.code is returned as the error
+func (s *testService) UnaryCall(ctx context.Context, req *testpb.SimpleRequest) (*testpb.SimpleResponse, error) {
+	if code := req.GetResponseStatus().GetCode(); code != 0 {
+		return nil, status.Error(codes.Code(code), req.GetResponseStatus().GetMessage())
+	}
+
+	method, _ := context0.Get(ctx, context0.ContextKeyCurrentMethod)
+	return &testpb.SimpleResponse{
+		Payload:    req.GetPayload(),
+		Username:   req.GetResponseStatus().GetMessage(),
+		OauthScope: method,
+	}, nil
+}
+
+func TestTemplateMatch(t *testing.T) {
+	template, err := parseTemplate("/v1/{name=shelves/*}/books/{book_id}:publish")
+	if err != nil {
+		t.Fatalf("parse err:%v", err)
+	}
+
+	values, ok := template.match("/v1/shelves/s1/books/b2:publish")
+	if !ok || values["name"] != "shelves/s1" || values["book_id"] != "b2" {
+		t.Fatalf("got %v %v", values, ok)
+	}
+	if _, ok := template.match("/v1/shelves/s1/books/b2"); ok {
+		t.Fatal("matched without the verb")
+	}
+	if prefix, exact := template.literalPrefix(); prefix != "/v1/shelves/" || exact {
+		t.Fatalf("got prefix %q exact %v", prefix, exact)
+	}
+
+	deep, _ := parseTemplate("/files/{path=**}")
+	if values, ok := deep.match("/files/a/b/c.txt"); !ok || values["path"] != "a/b/c.txt" {
+		t.Fatalf("got %v %v", values, ok)
+	}
+
+	if _, err := parseTemplate("/files/**/tail"); err == nil {
+		t.Fatal("** accepted before the last segment")
+	}
+}
+
+func TestBindRequest(t *testing.T) {
+	req := &testpb.SimpleRequest{}
+	if err := bindBody(req, "payload", []byte(`{"type":"COMPRESSABLE","body":"aGk="}`)); err != nil {
+		t.Fatalf("body err:%v", err)
+	}
+	if err := setField(req.ProtoReflect(), "response_status.message", "alice"); err != nil {
+		t.Fatalf("path err:%v", err)
+	}
+
+	query := url.Values{"responseSize": {"7"}, "payload": {"ignored"}, "unknown": {"x"}}
+	if err := bindQuery(req, query, map[string]bool{"payload": true}); err != nil {
+		t.Fatalf("query err:%v", err)
+	}
+
+	if req.GetResponseStatus().GetMessage() != "alice" || req.GetResponseSize() != 7 || string(req.GetPayload().GetBody()) != "hi" {
+		t.Fatalf("got %v", req)
+	}
+
+	if err := bindQuery(req, url.Values{"response_size": {"seven"}}, nil); err == nil {
+		t.Fatal("bad int32 query accepted")
+	}
+
+	whole := &testpb.SimpleRequest{}
+	if err := bindBody(whole, "*", []byte(`{"responseSize":3,"fillUsername":true}`)); err != nil {
+		t.Fatalf("body err:%v", err)
+	}
+	if whole.GetResponseSize() != 3 || !whole.GetFillUsername() {
+		t.Fatalf("got %v", whole)
+	}
+}
+
+func TestHttpStatusFromCode(t *testing.T) {
+	for code, want := range map[codes.Code]int{
+		codes.OK:                http.StatusOK,
+		codes.InvalidArgument:   http.StatusBadRequest,
+		codes.NotFound:          http.StatusNotFound,
+		codes.Unauthenticated:   http.StatusUnauthorized,
+		codes.ResourceExhausted: http.StatusTooManyRequests,
+		codes.Unavailable:       http.StatusServiceUnavailable,
+		codes.Code(99):          http.StatusInternalServerError,
+	} {
+		if got := HttpStatusFromCode(code); got != want {
+			t.Errorf("%v got %d, want %d", code, got, want)
+		}
+	}
+}
+
+func TestInProcessInvoke(t *testing.T) {
+	rpcServer := rpc_server.New("test")
+	testpb.RegisterTestServiceServer(rpcServer, &testService{})
+	gateway := New(http_server.New("test"), rpcServer)
+
+	output := (&testpb.SimpleResponse{}).ProtoReflect().Descriptor()
+	req := &testpb.SimpleRequest{ResponseStatus: &testpb.EchoStatus{Message: "alice"}}
+	resp, err := gateway.invoke(context0.NewContext(), "/grpc.testing.TestService/UnaryCall", req, output)
+	if err != nil {
+		t.Fatalf("invoke err:%v", err)
+	}
+
+	reply, ok := resp.(*testpb.SimpleResponse)
+	if !ok || reply.GetUsername() != "alice" {
+		t.Fatalf("got %v", resp)
+	}
+	if reply.GetOauthScope() != "UnaryCall" {
+		t.Fatalf("got current method %q, the server interceptors did not run", reply.GetOauthScope())
+	}
+
+	req.ResponseStatus.Code = int32(codes.NotFound)
+	if _, err := gateway.invoke(context0.NewContext(), "/grpc.testing.TestService/UnaryCall", req, output); status.Code(err) != codes.NotFound {
+		t.Fatalf("got err %v, want NotFound", err)
+	}
+	if _, err := gateway.invoke(context0.NewContext(), "/grpc.testing.TestService/Missing", req, output); status.Code(err) != codes.Unimplemented {
+		t.Fatalf("got err %v, want Unimplemented", err)
+	}
+}
+
+func newGatewayServer(t *testing.T, opts ...gatewayOption) *http_server.HttpServer {
+	rpcServer := rpc_server.New("test")
+	testpb.RegisterTestServiceServer(rpcServer, &testService{})
+	httpServer := http_server.New("test")
+
+	gateway := New(httpServer, rpcServer, opts...)
+	if err := gateway.Handle(http.MethodPost, "/v1/users/{response_status.message}", "/grpc.testing.TestService/UnaryCall", "payload"); err != nil {
+		t.Fatalf("handle err:%v", err)
+	}
+	if err := gateway.Handle(http.MethodGet, "/v1/users/{response_status.message}", "/grpc.testing.TestService/UnaryCall", ""); err != nil {
+		t.Fatalf("handle err:%v", err)
+	}
+
+	return httpServer
+}
+
+func serveGateway(server *http_server.HttpServer, method, target, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return recorder
+}
+
+func TestGatewayMapping(t *testing.T) {
+	server := newGatewayServer(t)
+
+	recorder := serveGateway(server, http.MethodPost, "/v1/users/alice?response_status.code=0&responseSize=7", `{"body":"aGk="}`)
+	reply := &struct {
+		Username string `json:"username"`
+		Payload  struct {
+			Body string `json:"body"`
+		} `json:"payload"`
+	}{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), reply); err != nil || recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d body %s err:%v", recorder.Code, recorder.Body.String(), err)
+	}
+	if reply.Username != "alice" || reply.Payload.Body != "aGk=" {
+		t.Fatalf("path and body not bound, got %s", recorder.Body.String())
+	}
+
+	// the query binds the other fields, here the status code returned by the service
+	recorder = serveGateway(server, http.MethodGet, "/v1/users/alice?response_status.code=5", "")
+	result := &http_server.Reply{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), result); err != nil || recorder.Code != http.StatusNotFound ||
+		result.Result != http_server.HttpResult(codes.NotFound) || result.Msg != "alice" {
+		t.Fatalf("got status %d body %s err:%v", recorder.Code, recorder.Body.String(), err)
+	}
+
+	if recorder = serveGateway(server, http.MethodGet, "/v1/users/alice?response_status.code=x", ""); recorder.Code != http.StatusBadRequest {
+		t.Fatalf("malformed query got status %d", recorder.Code)
+	}
+	if recorder = serveGateway(server, http.MethodGet, "/v1/groups/a", ""); recorder.Code != http.StatusNotFound {
+		t.Fatalf("no route got status %d", recorder.Code)
+	}
+}
+
+func TestGatewayMaxBody(t *testing.T) {
+	server := newGatewayServer(t, MaxBody(16))
+
+	if recorder := serveGateway(server, http.MethodPost, "/v1/users/alice", `{"body":"aGk="}`); recorder.Code != http.StatusOK {
+		t.Fatalf("body under the limit got status %d body %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := serveGateway(server, http.MethodPost, "/v1/users/alice", `{"body":"aGVsbG8gd29ybGQ="}`); recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("body over the limit got status %d body %s", recorder.Code, recorder.Body.String())
+	}
+}
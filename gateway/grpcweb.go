@@ -0,0 +1,423 @@
+package gateway
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"github.com/RealJonathanYip/framework/http_server"
+	"github.com/RealJonathanYip/framework/log"
+	"github.com/RealJonathanYip/framework/rpc_server"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	_GRPC_WEB_CONTENT_TYPE      = "application/grpc-web"
+	_GRPC_WEB_TEXT_CONTENT_TYPE = "application/grpc-web-text"
+	_FRAME_DATA                 = 0x00
+	_FRAME_TRAILER              = 0x80
+)
+
+// headers a grpc-web client sends besides the metadata
+var _grpcWebHeaders = []string{"content-type", "x-grpc-web", "x-user-agent", "grpc-timeout"}
+
+type grpcWebConf struct {
+	allowOrigins map[string]bool
+	metadata     map[string]bool
+	maxBody      int64
+}
+
+type grpcWebOption interface {
+	apply(*grpcWebConf)
+}
+
+type grpcWebOptionFunc func(*grpcWebConf)
+
+func (f grpcWebOptionFunc) apply(conf *grpcWebConf) {
+	f(conf)
+}
+
+// GrpcWebAllowOrigins lets pages of these origins call from another origin, e.g. https://app.example.com,
+// "*" for any, default same origin only
+func GrpcWebAllowOrigins(origins ...string) grpcWebOption {
+	return grpcWebOptionFunc(func(conf *grpcWebConf) {
+		for _, origin := range origins {
+			conf.allowOrigins[strings.TrimRight(origin, "/")] = true
+		}
+	})
+}
+
+// GrpcWebMetadata passes these request headers to the service as grpc metadata, default authorization only
+func GrpcWebMetadata(headers ...string) grpcWebOption {
+	return grpcWebOptionFunc(func(conf *grpcWebConf) {
+		for _, header := range headers {
+			conf.metadata[strings.ToLower(header)] = true
+		}
+	})
+}
+
+// GrpcWebMaxBody rejects request bodies larger than size bytes with ResourceExhausted, default 4MB like grpc
+func GrpcWebMaxBody(size int) grpcWebOption {
+	return grpcWebOptionFunc(func(conf *grpcWebConf) {
+		conf.maxBody = int64(size)
+	})
+}
+
+// MountGrpcWeb lets browsers call the services registered on rpcServer with the gRPC-Web protocol,
+// unary and server streaming methods are served in-process, client streaming is not supported by gRPC-Web
+//
+// Example: MountGrpcWeb(httpServer, rpcServer, GrpcWebAllowOrigins("https://app.example.com"), GrpcWebMetadata("x-tenant"))
+func MountGrpcWeb(httpServer *http_server.HttpServer, rpcServer *rpc_server.RpcServer, opts ...grpcWebOption) {
+	conf := &grpcWebConf{
+		allowOrigins: make(map[string]bool),
+		metadata:     map[string]bool{"authorization": true},
+		maxBody:      4 << 20,
+	}
+	for _, opt := range opts {
+		opt.apply(conf)
+	}
+
+	for _, desc := range rpcServer.ServiceDescs() {
+		descTemp := desc
+		httpServer.HandlePrefix(http.MethodPost, "/"+desc.ServiceName+"/", func(ctx context.Context, resp *http_server.Response, req *http_server.Request) {
+			if !conf.cors(resp, req) {
+				http.Error(resp, "origin not allowed", http.StatusForbidden)
+				return
+			}
+			conf.serve(ctx, resp, req, rpcServer, descTemp)
+		}, nil)
+		httpServer.HandlePrefix(http.MethodOptions, "/"+desc.ServiceName+"/", conf.preflight, nil)
+	}
+}
+
+// cors adds the CORS reply headers, false when the request comes from an origin not allowed
+func (c *grpcWebConf) cors(resp *http_server.Response, req *http_server.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	if !c.allowOrigins["*"] && !c.allowOrigins[origin] {
+		if originURL, err := url.Parse(origin); err == nil && originURL.Host == req.Host {
+			return true
+		}
+		return false
+	}
+
+	header := resp.Header()
+	header.Set("Access-Control-Allow-Origin", origin)
+	header.Add("Vary", "Origin")
+	return true
+}
+
+func (c *grpcWebConf) preflight(ctx context.Context, resp *http_server.Response, req *http_server.Request) {
+	if !c.cors(resp, req) {
+		http.Error(resp, "origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	allowHeaders := append([]string{}, _grpcWebHeaders...)
+	for header := range c.metadata {
+		allowHeaders = append(allowHeaders, header)
+	}
+
+	header := resp.Header()
+	header.Set("Access-Control-Allow-Methods", http.MethodPost)
+	header.Set("Access-Control-Allow-Headers", strings.Join(allowHeaders, ", "))
+	header.Set("Access-Control-Max-Age", "600")
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+func (c *grpcWebConf) serve(ctx context.Context, resp *http_server.Response, req *http_server.Request, rpcServer *rpc_server.RpcServer,
+	desc *grpc.ServiceDesc) {
+	contentType := req.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, _GRPC_WEB_CONTENT_TYPE) {
+		http.Error(resp, "unsupported content type: "+contentType, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	// streaming methods run until the browser goes away or grpc-timeout
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	timeout, err := parseGrpcTimeout(req.Header.Get("grpc-timeout"))
+	if err != nil {
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	go func() {
+		select {
+		case <-req.Context().Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	stream := &webStream{
+		ctx:         c.incomingMetadata(ctx, req.Header),
+		resp:        resp,
+		text:        strings.HasPrefix(contentType, _GRPC_WEB_TEXT_CONTENT_TYPE),
+		contentType: contentType,
+		header:      metadata.MD{},
+		trailer:     metadata.MD{},
+	}
+
+	if err := stream.readRequest(http.MaxBytesReader(resp, req.Body, c.maxBody)); err != nil {
+		stream.finish(err)
+		return
+	}
+
+	fullMethod := req.URL.Path
+	methodName := strings.TrimPrefix(fullMethod, "/"+desc.ServiceName+"/")
+	for _, method := range desc.Methods {
+		if method.MethodName != methodName {
+			continue
+		}
+
+		reply, err := rpcServer.Invoke(stream.ctx, fullMethod, stream.RecvMsg)
+		if err == nil {
+			err = stream.SendMsg(reply)
+		}
+		stream.finish(err)
+		return
+	}
+
+	for _, streamDesc := range desc.Streams {
+		if streamDesc.StreamName != methodName {
+			continue
+		}
+
+		if streamDesc.ClientStreams {
+			stream.finish(status.Errorf(codes.Unimplemented, "client streaming method %s is not supported by grpc-web", fullMethod))
+			return
+		}
+
+		stream.finish(rpcServer.InvokeStream(stream.ctx, fullMethod, stream))
+		return
+	}
+
+	stream.finish(status.Errorf(codes.Unimplemented, "unknown method %s", fullMethod))
+}
+
+// incomingMetadata passes the request headers of GrpcWebMetadata to the service like grpc metadata
+func (c *grpcWebConf) incomingMetadata(ctx context.Context, header http.Header) context.Context {
+	meta := metadata.MD{}
+	for key, values := range header {
+		key = strings.ToLower(key)
+		if c.metadata[key] {
+			meta.Append(key, values...)
+		}
+	}
+
+	return metadata.NewIncomingContext(ctx, meta)
+}
+
+// parseGrpcTimeout parses the grpc-timeout header like 100m or 5S, 0 when there is none
+func parseGrpcTimeout(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	units := map[byte]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second, 'm': time.Millisecond,
+		'u': time.Microsecond, 'n': time.Nanosecond}
+	unit, exist := units[value[len(value)-1]]
+	if len(value) < 2 || len(value) > 9 || !exist {
+		return 0, errors.Errorf("malformed grpc-timeout: %s", value)
+	}
+
+	amount, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || amount < 0 {
+		return 0, errors.Errorf("malformed grpc-timeout: %s", value)
+	}
+
+	return time.Duration(amount) * unit, nil
+}
+
+// webStream implements grpc.ServerStream over a gRPC-Web reply
+type webStream struct {
+	ctx         context.Context
+	resp        *http_server.Response
+	text        bool
+	contentType string
+	request     []byte
+	received    bool
+	header      metadata.MD
+	trailer     metadata.MD
+	headerSent  bool
+}
+
+func (s *webStream) readRequest(body io.Reader) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return status.Errorf(codes.ResourceExhausted, "request larger than %d bytes", maxBytesErr.Limit)
+		}
+		return status.Errorf(codes.Internal, "read request fail: %v", err)
+	}
+
+	if s.text {
+		decoded := make([]byte, base64.StdEncoding.DecodedLen(len(data)))
+		n, err := base64.StdEncoding.Decode(decoded, data)
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "decode grpc-web-text fail: %v", err)
+		}
+		data = decoded[:n]
+	}
+
+	if len(data) < 5 {
+		return status.Errorf(codes.InvalidArgument, "grpc-web request frame is too short")
+	}
+
+	if data[0] != _FRAME_DATA {
+		return status.Errorf(codes.Unimplemented, "compressed grpc-web request is not supported")
+	}
+
+	length := binary.BigEndian.Uint32(data[1:5])
+	if uint32(len(data)-5) < length {
+		return status.Errorf(codes.InvalidArgument, "grpc-web request frame is truncated")
+	}
+
+	s.request = data[5 : 5+length]
+	return nil
+}
+
+func (s *webStream) SetHeader(md metadata.MD) error {
+	if s.headerSent {
+		return errors.New("grpc-web header already sent")
+	}
+
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func (s *webStream) SendHeader(md metadata.MD) error {
+	if err := s.SetHeader(md); err != nil {
+		return err
+	}
+
+	s.writeHeader()
+	return nil
+}
+
+func (s *webStream) SetTrailer(md metadata.MD) {
+	s.trailer = metadata.Join(s.trailer, md)
+}
+
+func (s *webStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *webStream) SendMsg(m interface{}) error {
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return status.Errorf(codes.Internal, "reply %T is not a proto message", m)
+	}
+
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return status.Errorf(codes.Internal, "marshal reply fail: %v", err)
+	}
+
+	s.writeHeader()
+	return s.writeFrame(_FRAME_DATA, data)
+}
+
+func (s *webStream) RecvMsg(m interface{}) error {
+	if s.received {
+		return io.EOF
+	}
+	s.received = true
+
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return status.Errorf(codes.Internal, "request %T is not a proto message", m)
+	}
+
+	if err := proto.Unmarshal(s.request, msg); err != nil {
+		return status.Errorf(codes.InvalidArgument, "unmarshal request fail: %v", err)
+	}
+
+	return nil
+}
+
+func (s *webStream) writeHeader() {
+	if s.headerSent {
+		return
+	}
+	s.headerSent = true
+
+	header := s.resp.Header()
+	header.Set("Content-Type", s.contentType)
+	exposeHeaders := []string{"grpc-status", "grpc-message"}
+	for key := range s.header {
+		exposeHeaders = append(exposeHeaders, key)
+	}
+	header.Set("Access-Control-Expose-Headers", strings.Join(exposeHeaders, ", "))
+	for key, values := range s.header {
+		for _, value := range values {
+			header.Add(key, value)
+		}
+	}
+
+	s.resp.BeforeReply(s.ctx)
+	s.resp.WriteHeader(http.StatusOK)
+}
+
+// finish writes the trailer frame carrying the grpc status
+func (s *webStream) finish(err error) {
+	st := status.Convert(err)
+	if err != nil && st.Code() != codes.NotFound && st.Code() != codes.InvalidArgument {
+		log.Warningf(s.ctx, "grpc-web call fail: %v", err)
+	}
+
+	s.writeHeader()
+
+	builder := strings.Builder{}
+	builder.WriteString(fmt.Sprintf("grpc-status: %d\r\n", st.Code()))
+	if st.Message() != "" {
+		builder.WriteString(fmt.Sprintf("grpc-message: %s\r\n", url.PathEscape(st.Message())))
+	}
+	for key, values := range s.trailer {
+		for _, value := range values {
+			builder.WriteString(fmt.Sprintf("%s: %s\r\n", key, value))
+		}
+	}
+
+	_ = s.writeFrame(_FRAME_TRAILER, []byte(builder.String()))
+}
+
+func (s *webStream) writeFrame(flag byte, data []byte) error {
+	frame := make([]byte, 5+len(data))
+	frame[0] = flag
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(data)))
+	copy(frame[5:], data)
+
+	if s.text {
+		frame = []byte(base64.StdEncoding.EncodeToString(frame))
+	}
+
+	if _, err := s.resp.Write(frame); err != nil {
+		return status.Errorf(codes.Unavailable, "write reply fail: %v", err)
+	}
+
+	if flusher, ok := s.resp.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+
+	return nil
+}
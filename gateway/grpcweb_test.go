@@ -0,0 +1,265 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"github.com/RealJonathanYip/framework/http_server"
+	"github.com/RealJonathanYip/framework/rpc_server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// StreamingOutputCall sends one reply for each response parameter
+func (s *testService) StreamingOutputCall(req *testpb.StreamingOutputCallRequest, stream testpb.TestService_StreamingOutputCallServer) error {
+	for _, param := range req.GetResponseParameters() {
+		payload := &testpb.Payload{Body: bytes.Repeat([]byte("a"), int(param.GetSize()))}
+		if err := stream.Send(&testpb.StreamingOutputCallResponse{Payload: payload}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func newGrpcWebServer() *http_server.HttpServer {
+	rpcServer := rpc_server.New("test")
+	testpb.RegisterTestServiceServer(rpcServer, &testService{})
+	httpServer := http_server.New("test")
+	MountGrpcWeb(httpServer, rpcServer)
+
+	return httpServer
+}
+
+func grpcWebFrame(t *testing.T, msg proto.Message) []byte {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatalf("marshal err:%v", err)
+	}
+
+	frame := make([]byte, 5+len(data))
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(data)))
+	copy(frame[5:], data)
+	return frame
+}
+
+// callGrpcWeb posts one request frame and splits the reply into data frames and the trailer
+func callGrpcWeb(t *testing.T, server *http_server.HttpServer, method string, msg proto.Message, text bool) ([][]byte, string) {
+	body, contentType := grpcWebFrame(t, msg), _GRPC_WEB_CONTENT_TYPE
+	if text {
+		body, contentType = []byte(base64.StdEncoding.EncodeToString(body)), _GRPC_WEB_TEXT_CONTENT_TYPE
+	}
+
+	req := httptest.NewRequest(http.MethodPost, method, bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != contentType {
+		t.Fatalf("got status %d content type %q", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+
+	reply := recorder.Body.Bytes()
+	if text {
+		// every frame is encoded on its own, the 4 byte groups still decode one by one
+		decoded := make([]byte, 0)
+		for i := 0; i+4 <= len(reply); i += 4 {
+			data, err := base64.StdEncoding.DecodeString(string(reply[i : i+4]))
+			if err != nil {
+				t.Fatalf("decode %q err:%v", reply, err)
+			}
+			decoded = append(decoded, data...)
+		}
+		reply = decoded
+	}
+
+	var messages [][]byte
+	for len(reply) >= 5 {
+		flag, length := reply[0], binary.BigEndian.Uint32(reply[1:5])
+		data := reply[5 : 5+length]
+		reply = reply[5+length:]
+		if flag == _FRAME_TRAILER {
+			return messages, string(data)
+		}
+		messages = append(messages, data)
+	}
+
+	t.Fatal("no trailer frame")
+	return nil, ""
+}
+
+func TestGrpcWebUnary(t *testing.T) {
+	server := newGrpcWebServer()
+	req := &testpb.SimpleRequest{ResponseStatus: &testpb.EchoStatus{Message: "alice"}}
+
+	for _, text := range []bool{false, true} {
+		messages, trailer := callGrpcWeb(t, server, "/grpc.testing.TestService/UnaryCall", req, text)
+		if len(messages) != 1 || !strings.Contains(trailer, "grpc-status: 0\r\n") {
+			t.Fatalf("text:%v got %d messages trailer %q", text, len(messages), trailer)
+		}
+
+		reply := &testpb.SimpleResponse{}
+		if err := proto.Unmarshal(messages[0], reply); err != nil || reply.GetUsername() != "alice" {
+			t.Fatalf("text:%v got %v err:%v", text, reply, err)
+		}
+	}
+}
+
+func TestGrpcWebError(t *testing.T) {
+	server := newGrpcWebServer()
+
+	req := &testpb.SimpleRequest{ResponseStatus: &testpb.EchoStatus{Code: int32(codes.NotFound), Message: "no such user"}}
+	messages, trailer := callGrpcWeb(t, server, "/grpc.testing.TestService/UnaryCall", req, false)
+	if len(messages) != 0 || !strings.Contains(trailer, "grpc-status: 5\r\n") || !strings.Contains(trailer, "grpc-message: no%20such%20user\r\n") {
+		t.Fatalf("got %d messages trailer %q", len(messages), trailer)
+	}
+
+	_, trailer = callGrpcWeb(t, server, "/grpc.testing.TestService/StreamingInputCall", &testpb.StreamingInputCallRequest{}, false)
+	if !strings.Contains(trailer, "grpc-status: 12\r\n") {
+		t.Fatalf("client streaming got trailer %q, want Unimplemented", trailer)
+	}
+}
+
+func TestGrpcWebServerStreaming(t *testing.T) {
+	server := newGrpcWebServer()
+
+	req := &testpb.StreamingOutputCallRequest{ResponseParameters: []*testpb.ResponseParameters{{Size: 1}, {Size: 2}, {Size: 3}}}
+	messages, trailer := callGrpcWeb(t, server, "/grpc.testing.TestService/StreamingOutputCall", req, true)
+	if len(messages) != 3 || !strings.Contains(trailer, "grpc-status: 0\r\n") {
+		t.Fatalf("got %d messages trailer %q", len(messages), trailer)
+	}
+
+	for i, data := range messages {
+		reply := &testpb.StreamingOutputCallResponse{}
+		if err := proto.Unmarshal(data, reply); err != nil || len(reply.GetPayload().GetBody()) != i+1 {
+			t.Fatalf("message %d got %v err:%v", i, reply, err)
+		}
+	}
+}
+
+// incomingCapture keeps the metadata and deadline the service got in the last call
+type incomingCapture struct {
+	metadata    metadata.MD
+	hasDeadline bool
+}
+
+func newCorsServer(capture *incomingCapture) *http_server.HttpServer {
+	rpcServer := rpc_server.New("test", rpc_server.UnaryInterceptorBeforeTrace(
+		func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			capture.metadata, _ = metadata.FromIncomingContext(ctx)
+			_, capture.hasDeadline = ctx.Deadline()
+			return handler(ctx, req)
+		}))
+	testpb.RegisterTestServiceServer(rpcServer, &testService{})
+	httpServer := http_server.New("test")
+	MountGrpcWeb(httpServer, rpcServer, GrpcWebAllowOrigins("https://app.example.com"), GrpcWebMetadata("X-Tenant"))
+
+	return httpServer
+}
+
+func serveGrpcWeb(t *testing.T, server *http_server.HttpServer, method string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/grpc.testing.TestService/UnaryCall", bytes.NewReader(grpcWebFrame(t, &testpb.SimpleRequest{})))
+	req.Header.Set("Content-Type", _GRPC_WEB_CONTENT_TYPE)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+
+	return recorder
+}
+
+func TestGrpcWebCors(t *testing.T) {
+	server := newCorsServer(&incomingCapture{})
+	allowed := http.Header{"Origin": {"https://app.example.com"}}
+	other := http.Header{"Origin": {"https://evil.example.com"}}
+
+	recorder := serveGrpcWeb(t, server, http.MethodOptions, allowed)
+	allowHeaders := recorder.Header().Get("Access-Control-Allow-Headers")
+	if recorder.Code != http.StatusNoContent || recorder.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		!strings.Contains(allowHeaders, "x-tenant") || !strings.Contains(allowHeaders, "grpc-timeout") {
+		t.Fatalf("preflight got status %d header %v", recorder.Code, recorder.Header())
+	}
+	if recorder = serveGrpcWeb(t, server, http.MethodOptions, other); recorder.Code != http.StatusForbidden {
+		t.Fatalf("preflight from another origin got status %d", recorder.Code)
+	}
+
+	recorder = serveGrpcWeb(t, server, http.MethodPost, allowed)
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Fatalf("call from allowed origin got status %d header %v", recorder.Code, recorder.Header())
+	}
+	// httptest requests are sent to example.com
+	recorder = serveGrpcWeb(t, server, http.MethodPost, http.Header{"Origin": {"http://example.com"}})
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("call from same origin got status %d header %v", recorder.Code, recorder.Header())
+	}
+	if recorder = serveGrpcWeb(t, server, http.MethodPost, other); recorder.Code != http.StatusForbidden {
+		t.Fatalf("call from another origin got status %d", recorder.Code)
+	}
+}
+
+func TestGrpcWebTimeout(t *testing.T) {
+	capture := &incomingCapture{}
+	server := newCorsServer(capture)
+
+	if recorder := serveGrpcWeb(t, server, http.MethodPost, http.Header{"Grpc-Timeout": {"10x"}}); recorder.Code != http.StatusBadRequest {
+		t.Fatalf("malformed grpc-timeout got status %d", recorder.Code)
+	}
+
+	serveGrpcWeb(t, server, http.MethodPost, nil)
+	if capture.hasDeadline {
+		t.Fatal("deadline set without grpc-timeout")
+	}
+	serveGrpcWeb(t, server, http.MethodPost, http.Header{"Grpc-Timeout": {"5S"}})
+	if !capture.hasDeadline {
+		t.Fatal("grpc-timeout is not the deadline of the call")
+	}
+
+	if timeout, err := parseGrpcTimeout("100m"); err != nil || timeout != 100*time.Millisecond {
+		t.Fatalf("got %v err:%v", timeout, err)
+	}
+}
+
+func TestGrpcWebMetadata(t *testing.T) {
+	capture := &incomingCapture{}
+	server := newCorsServer(capture)
+
+	serveGrpcWeb(t, server, http.MethodPost, http.Header{"Authorization": {"Bearer token"}, "Cookie": {"session=1"},
+		"X-Tenant": {"a"}, "X-Other": {"b"}})
+	// the trace keys are added by the framework
+	if got := capture.metadata.Get("authorization"); len(got) != 1 || got[0] != "Bearer token" {
+		t.Fatalf("service got authorization %v", got)
+	}
+	if got := capture.metadata.Get("x-tenant"); len(got) != 1 || got[0] != "a" {
+		t.Fatalf("service got x-tenant %v", got)
+	}
+	if len(capture.metadata.Get("cookie")) != 0 || len(capture.metadata.Get("x-other")) != 0 {
+		t.Fatalf("service got metadata %v not allowed", capture.metadata)
+	}
+}
+
+func TestGrpcWebMaxBody(t *testing.T) {
+	rpcServer := rpc_server.New("test")
+	testpb.RegisterTestServiceServer(rpcServer, &testService{})
+	server := http_server.New("test")
+	MountGrpcWeb(server, rpcServer, GrpcWebMaxBody(16))
+
+	req := &testpb.SimpleRequest{ResponseStatus: &testpb.EchoStatus{Message: "alice"}}
+	if _, trailer := callGrpcWeb(t, server, "/grpc.testing.TestService/UnaryCall", req, false); !strings.Contains(trailer, "grpc-status: 0\r\n") {
+		t.Fatalf("body under the limit got trailer %q", trailer)
+	}
+
+	req.ResponseStatus.Message = strings.Repeat("a", 16)
+	if _, trailer := callGrpcWeb(t, server, "/grpc.testing.TestService/UnaryCall", req, false); !strings.Contains(trailer, "grpc-status: 8\r\n") {
+		t.Fatalf("body over the limit got trailer %q, want ResourceExhausted", trailer)
+	}
+}
@@ -0,0 +1,178 @@
+package gateway
+
+import (
+	"github.com/pkg/errors"
+	"strings"
+)
+
+const (
+	segmentLiteral = iota
+	segmentWildcard
+	segmentDeepWildcard
+)
+
+type segment struct {
+	kind    int
+	literal string
+}
+
+type variable struct {
+	fieldPath string
+	start     int
+	end       int
+}
+
+// pathTemplate is a google.api.http path template, e.g. /v1/{name=shelves/*}/books/{book_id}:publish
+type pathTemplate struct {
+	raw       string
+	segments  []segment
+	variables []variable
+	verb      string
+}
+
+func parseTemplate(raw string) (*pathTemplate, error) {
+	if !strings.HasPrefix(raw, "/") {
+		return nil, errors.Errorf("path template:%s must start with /", raw)
+	}
+
+	template := &pathTemplate{raw: raw}
+	body := raw[1:]
+	if index := strings.LastIndex(body, ":"); index >= 0 && index > strings.LastIndex(body, "}") &&
+		index > strings.LastIndex(body, "/") {
+		template.verb = body[index+1:]
+		body = body[:index]
+	}
+
+	for len(body) > 0 {
+		var token string
+		if strings.HasPrefix(body, "{") {
+			end := strings.Index(body, "}")
+			if end < 0 {
+				return nil, errors.Errorf("path template:%s has unclosed variable", raw)
+			}
+			token, body = body[:end+1], body[end+1:]
+		} else if end := strings.Index(body, "/"); end >= 0 {
+			token, body = body[:end], body[end:]
+		} else {
+			token, body = body, ""
+		}
+
+		if err := template.appendToken(token); err != nil {
+			return nil, err
+		}
+
+		if len(body) > 0 {
+			if body[0] != '/' {
+				return nil, errors.Errorf("path template:%s is malformed near %s", raw, body)
+			}
+			body = body[1:]
+		}
+	}
+
+	for i, seg := range template.segments {
+		if seg.kind == segmentDeepWildcard && i != len(template.segments)-1 {
+			return nil, errors.Errorf("path template:%s only allows ** as the last segment", raw)
+		}
+	}
+
+	return template, nil
+}
+
+func (t *pathTemplate) appendToken(token string) error {
+	if !strings.HasPrefix(token, "{") {
+		t.segments = append(t.segments, newSegment(token))
+		return nil
+	}
+
+	fieldPath, pattern := token[1:len(token)-1], "*"
+	if index := strings.Index(fieldPath, "="); index >= 0 {
+		fieldPath, pattern = fieldPath[:index], fieldPath[index+1:]
+	}
+	if fieldPath == "" || pattern == "" {
+		return errors.Errorf("path template:%s has empty variable %s", t.raw, token)
+	}
+
+	start := len(t.segments)
+	for _, part := range strings.Split(pattern, "/") {
+		t.segments = append(t.segments, newSegment(part))
+	}
+	t.variables = append(t.variables, variable{fieldPath: fieldPath, start: start, end: len(t.segments)})
+
+	return nil
+}
+
+func newSegment(token string) segment {
+	switch token {
+	case "*":
+		return segment{kind: segmentWildcard}
+	case "**":
+		return segment{kind: segmentDeepWildcard}
+	default:
+		return segment{kind: segmentLiteral, literal: token}
+	}
+}
+
+// literalPrefix returns the leading literal part of the template and whether the whole template is literal
+func (t *pathTemplate) literalPrefix() (string, bool) {
+	builder := strings.Builder{}
+	for _, seg := range t.segments {
+		if seg.kind != segmentLiteral {
+			builder.WriteString("/")
+			return builder.String(), false
+		}
+
+		builder.WriteString("/")
+		builder.WriteString(seg.literal)
+	}
+
+	if t.verb != "" {
+		builder.WriteString(":" + t.verb)
+	}
+
+	return builder.String(), true
+}
+
+// match returns the variable values bound by path
+func (t *pathTemplate) match(path string) (map[string]string, bool) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, false
+	}
+	path = path[1:]
+
+	if t.verb != "" {
+		if !strings.HasSuffix(path, ":"+t.verb) {
+			return nil, false
+		}
+		path = strings.TrimSuffix(path, ":"+t.verb)
+	}
+
+	parts := strings.Split(path, "/")
+	deep := len(t.segments) > 0 && t.segments[len(t.segments)-1].kind == segmentDeepWildcard
+	if deep && len(parts) < len(t.segments) || !deep && len(parts) != len(t.segments) {
+		return nil, false
+	}
+
+	for i, seg := range t.segments {
+		switch seg.kind {
+		case segmentLiteral:
+			if parts[i] != seg.literal {
+				return nil, false
+			}
+		case segmentWildcard:
+			if parts[i] == "" {
+				return nil, false
+			}
+		}
+	}
+
+	values := make(map[string]string, len(t.variables))
+	for _, v := range t.variables {
+		end := v.end
+		if end == len(t.segments) && deep {
+			end = len(parts)
+		}
+		values[v.fieldPath] = strings.Join(parts[v.start:end], "/")
+	}
+
+	return values, true
+}
@@ -0,0 +1,219 @@
+package http_client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+type Client struct {
+	client  *http.Client
+	timeout time.Duration
+	header  http.Header
+	retry   *RetryPolicy
+}
+
+type clientConf struct {
+	base    http.RoundTripper
+	timeout time.Duration
+	header  http.Header
+	retry   *RetryPolicy
+}
+
+type clientOption interface {
+	apply(*clientConf)
+}
+
+type clientOptionFunc func(*clientConf)
+
+func (f clientOptionFunc) apply(conf *clientConf) {
+	f(conf)
+}
+
+// BaseTransport sets the RoundTripper wrapped by the tracing Transport, default http.DefaultTransport
+func BaseTransport(base http.RoundTripper) clientOption {
+	return clientOptionFunc(func(conf *clientConf) {
+		conf.base = base
+	})
+}
+
+// Timeout sets the default timeout of every call including reading the body, default 10s
+func Timeout(timeout time.Duration) clientOption {
+	return clientOptionFunc(func(conf *clientConf) {
+		conf.timeout = timeout
+	})
+}
+
+// Header is sent with every call
+func Header(key, value string) clientOption {
+	return clientOptionFunc(func(conf *clientConf) {
+		conf.header.Add(key, value)
+	})
+}
+
+type callConf struct {
+	timeout time.Duration
+	header  http.Header
+	retry   *RetryPolicy
+}
+
+type callOption interface {
+	apply(*callConf)
+}
+
+type callOptionFunc func(*callConf)
+
+func (f callOptionFunc) apply(conf *callConf) {
+	f(conf)
+}
+
+// CallTimeout overrides the client timeout for one call, 0 means no timeout besides the ctx deadline
+func CallTimeout(timeout time.Duration) callOption {
+	return callOptionFunc(func(conf *callConf) {
+		conf.timeout = timeout
+	})
+}
+
+func CallHeader(key, value string) callOption {
+	return callOptionFunc(func(conf *callConf) {
+		conf.header.Add(key, value)
+	})
+}
+
+// StatusError is returned by the json helpers when the reply status is not 2xx
+type StatusError struct {
+	StatusCode int
+	Body       []byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("http status %d: %s", e.StatusCode, e.Body)
+}
+
+func New(opts ...clientOption) *Client {
+	conf := clientConf{timeout: 10 * time.Second, header: make(http.Header)}
+	for _, opt := range opts {
+		opt.apply(&conf)
+	}
+
+	return &Client{
+		client:  &http.Client{Transport: NewTransport(conf.base)},
+		timeout: conf.timeout,
+		header:  conf.header,
+		retry:   conf.retry,
+	}
+}
+
+// Do sends req with ctx, the caller must close the body of the response,
+// the timeout covers all the attempts when a retry policy is set
+func (c *Client) Do(ctx context.Context, req *http.Request, opts ...callOption) (*http.Response, error) {
+	conf := callConf{timeout: c.timeout, header: make(http.Header), retry: c.retry}
+	for _, opt := range opts {
+		opt.apply(&conf)
+	}
+
+	cancel := context.CancelFunc(func() {})
+	if conf.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, conf.timeout)
+	}
+
+	// the caller may send req again, its header must not collect ours
+	req = req.Clone(ctx)
+	for _, header := range []http.Header{c.header, conf.header} {
+		for key, values := range header {
+			req.Header.Del(key)
+			for _, value := range values {
+				req.Header.Add(key, value)
+			}
+		}
+	}
+
+	resp, err := c.doWithRetry(ctx, req, conf.retry)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+func (c *Client) Get(ctx context.Context, url string, opts ...callOption) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Do(ctx, req, opts...)
+}
+
+// GetJson decodes the json reply of url into result
+func (c *Client) GetJson(ctx context.Context, url string, result interface{}, opts ...callOption) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	return c.doJson(ctx, req, result, opts...)
+}
+
+// PostJson posts body as json and decodes the json reply into result, result can be nil
+func (c *Client) PostJson(ctx context.Context, url string, body, result interface{}, opts ...callOption) error {
+	data, ok := body.([]byte)
+	if !ok {
+		dataTemp, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		data = dataTemp
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+	return c.doJson(ctx, req, result, opts...)
+}
+
+func (c *Client) doJson(ctx context.Context, req *http.Request, result interface{}, opts ...callOption) error {
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.Do(ctx, req, opts...)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &StatusError{StatusCode: resp.StatusCode, Body: data}
+	}
+
+	if result == nil || len(data) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(data, result)
+}
+
+// cancelBody releases the per call timeout when the body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,192 @@
+package http_client
+
+import (
+	"context"
+	"github.com/RealJonathanYip/framework/log"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy retries a call on transport errors and RetryableStatus replies,
+// the wait between attempts grows exponentially with jitter and never crosses the ctx deadline
+type RetryPolicy struct {
+	// MaxAttempts counts the first attempt, values below 2 disable retry
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	// MaxBackoff also caps the Retry-After of the server, 0 for no cap
+	MaxBackoff time.Duration
+	Multiplier float64
+	// Jitter is the fraction of the backoff randomly taken off, between 0 and 1
+	Jitter          float64
+	RetryableStatus []int
+	// RetryNonIdempotent allows retrying POST and PATCH, they are retried by default only with an Idempotency-Key header
+	RetryNonIdempotent bool
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:     3,
+		InitialBackoff:  100 * time.Millisecond,
+		MaxBackoff:      2 * time.Second,
+		Multiplier:      2,
+		Jitter:          0.2,
+		RetryableStatus: []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+	}
+}
+
+// Retry enables the policy for every call of the client
+func Retry(policy RetryPolicy) clientOption {
+	return clientOptionFunc(func(conf *clientConf) {
+		conf.retry = &policy
+	})
+}
+
+// CallRetry overrides the client retry policy for one call
+func CallRetry(policy RetryPolicy) callOption {
+	return callOptionFunc(func(conf *callConf) {
+		conf.retry = &policy
+	})
+}
+
+func CallNoRetry() callOption {
+	return callOptionFunc(func(conf *callConf) {
+		conf.retry = nil
+	})
+}
+
+func (p *RetryPolicy) canRetry(req *http.Request) bool {
+	if p == nil || p.MaxAttempts < 2 {
+		return false
+	}
+
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete, http.MethodTrace:
+		return true
+	}
+
+	return p.RetryNonIdempotent || req.Header.Get("Idempotency-Key") != ""
+}
+
+func (p *RetryPolicy) shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	for _, status := range p.RetryableStatus {
+		if resp.StatusCode == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+// backoff returns the wait before the next attempt, attempt starts from 1
+func (p *RetryPolicy) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if wait, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+				wait = p.MaxBackoff
+			}
+			return wait
+		}
+	}
+
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	wait := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && wait > float64(p.MaxBackoff) {
+		wait = float64(p.MaxBackoff)
+	}
+
+	if p.Jitter > 0 {
+		wait -= wait * math.Min(p.Jitter, 1) * rand.Float64()
+	}
+
+	return time.Duration(wait)
+}
+
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		wait := time.Until(date)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+
+	return 0, false
+}
+
+// doWithRetry sends req until it succeeds, is not retryable or the attempts, deadline are used up
+func (c *Client) doWithRetry(ctx context.Context, req *http.Request, policy *RetryPolicy) (*http.Response, error) {
+	if !policy.canRetry(req) {
+		return c.client.Do(req)
+	}
+
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+
+		resp, err := c.client.Do(req)
+		if ctx.Err() != nil || attempt >= policy.MaxAttempts || !policy.shouldRetry(resp, err) {
+			return resp, err
+		}
+
+		wait := policy.backoff(attempt, resp)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= wait {
+			log.Warningf(ctx, "http retry give up %s %s attempt:%d wait:%v exceeds deadline", req.Method, logURL(req.URL), attempt, wait)
+			return resp, err
+		}
+
+		if err != nil {
+			log.Warningf(ctx, "http retry %s %s attempt:%d/%d after %v err:%v", req.Method, logURL(req.URL), attempt+1, policy.MaxAttempts, wait, urlErrorCause(err))
+		} else {
+			log.Warningf(ctx, "http retry %s %s attempt:%d/%d after %v status:%s", req.Method, logURL(req.URL), attempt+1, policy.MaxAttempts, wait, resp.Status)
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			_ = resp.Body.Close()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// urlErrorCause drops the full url the http client puts in its errors, it is logged redacted beside
+func urlErrorCause(err error) error {
+	if urlErr, ok := err.(*url.Error); ok {
+		return urlErr.Err
+	}
+
+	return err
+}
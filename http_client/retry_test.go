@@ -0,0 +1,153 @@
+package http_client
+
+import (
+	"context"
+	"github.com/RealJonathanYip/framework/context0"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2}
+
+	if got := policy.backoff(1, nil); got != 100*time.Millisecond {
+		t.Errorf("first attempt waits %v", got)
+	}
+	if got := policy.backoff(3, nil); got != 400*time.Millisecond {
+		t.Errorf("third attempt waits %v", got)
+	}
+	if got := policy.backoff(10, nil); got != time.Second {
+		t.Errorf("backoff not capped, got %v", got)
+	}
+
+	resp := &http.Response{Header: http.Header{"Retry-After": {"0"}}}
+	if got := policy.backoff(3, resp); got != 0 {
+		t.Errorf("Retry-After 0 waits %v", got)
+	}
+	resp.Header.Set("Retry-After", "3600")
+	if got := policy.backoff(1, resp); got != time.Second {
+		t.Errorf("Retry-After not capped by MaxBackoff, got %v", got)
+	}
+	if got := (&RetryPolicy{}).backoff(1, resp); got != time.Hour {
+		t.Errorf("Retry-After without MaxBackoff waits %v", got)
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := policy.backoff(1, nil); got < 50*time.Millisecond || got > 100*time.Millisecond {
+			t.Fatalf("jittered backoff %v out of range", got)
+		}
+	}
+}
+
+func newFlakyServer(failures int32, attempts *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(attempts, 1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+}
+
+func TestRetryUntilSuccess(t *testing.T) {
+	var attempts int32
+	server := newFlakyServer(2, &attempts)
+	defer server.Close()
+
+	policy := DefaultRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	client := New(Retry(policy))
+
+	resp, err := client.Get(context0.NewContext(), server.URL)
+	if err != nil {
+		t.Fatalf("Get err:%v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || atomic.LoadInt32(&attempts) != 3 {
+		t.Fatalf("got status %d after %d attempts", resp.StatusCode, attempts)
+	}
+}
+
+func TestRetryNonIdempotent(t *testing.T) {
+	var attempts int32
+	server := newFlakyServer(1, &attempts)
+	defer server.Close()
+
+	policy := DefaultRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	client := New(Retry(policy))
+
+	err := client.PostJson(context0.NewContext(), server.URL, "{}", nil)
+	if statusErr, ok := err.(*StatusError); !ok || statusErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("got err %v, want the 503 of the only attempt", err)
+	}
+	if got := atomic.LoadInt32(&attempts); got != 1 {
+		t.Fatalf("POST sent %d times", got)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader("{}"))
+	req.Header.Set("Idempotency-Key", "k1")
+	resp, err := client.Do(context0.NewContext(), req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("got %v err:%v, want the POST with Idempotency-Key retried", resp, err)
+	}
+	resp.Body.Close()
+}
+
+func TestRetryAfterDeadline(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := New(Retry(DefaultRetryPolicy()))
+	ctx, cancel := context.WithTimeout(context0.NewContext(), 500*time.Millisecond)
+	defer cancel()
+
+	now := time.Now()
+	resp, err := client.Get(ctx, server.URL)
+	if err != nil {
+		t.Fatalf("Get err:%v", err)
+	}
+	resp.Body.Close()
+
+	if got := atomic.LoadInt32(&attempts); got != 1 || time.Since(now) >= 500*time.Millisecond {
+		t.Fatalf("got %d attempts in %v, want to give up before the deadline", got, time.Since(now))
+	}
+}
+
+func TestRetryLogRedactsURL(t *testing.T) {
+	var attempts int32
+	server := newFlakyServer(1, &attempts)
+	defer server.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	client := New(Retry(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond, RetryableStatus: []int{http.StatusServiceUnavailable}}))
+	logs := watchLogs("http retry")
+
+	resp, err := client.Get(context0.NewContext(), server.URL+"/users?token=secret")
+	if err != nil {
+		t.Fatalf("Get err:%v", err)
+	}
+	resp.Body.Close()
+	if msg := <-logs; !strings.Contains(msg, server.URL+"/users attempt:2/2") || strings.Contains(msg, "secret") {
+		t.Fatalf("status retry got log %q", msg)
+	}
+
+	if _, err := client.Get(context0.NewContext(), closed.URL+"/users?token=secret"); err == nil {
+		t.Fatal("closed server got no err")
+	}
+	if msg := <-logs; !strings.Contains(msg, closed.URL+"/users attempt:2/2") || strings.Contains(msg, "secret") {
+		t.Fatalf("err retry got log %q", msg)
+	}
+}
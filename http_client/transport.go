@@ -0,0 +1,116 @@
+package http_client
+
+import (
+	"fmt"
+	"github.com/RealJonathanYip/framework/context0"
+	"github.com/RealJonathanYip/framework/log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"runtime"
+	"time"
+)
+
+var (
+	processName string
+)
+
+func init() {
+	processName = fmt.Sprintf("<process>-<%s>", path.Base(os.Args[0]))
+}
+
+// Transport propagates context0 metadata of the request context as headers and logs every round trip,
+// it is the http counterpart of interceptor.WithClientUnaryInterceptor
+type Transport struct {
+	Base http.RoundTripper
+}
+
+func NewTransport(base http.RoundTripper) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &Transport{Base: base}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := context0.Copy(req.Context())
+
+	upstreamService, exist := context0.Get(ctx, context0.ContextKeyUpstreamService)
+	if !exist {
+		upstreamService = "unknow"
+	}
+	upstreamMethod, exist := context0.Get(ctx, context0.ContextKeyUpstreamMethod)
+	if !exist {
+		upstreamMethod = "unknow"
+	}
+
+	currentMethod, exist := context0.Get(ctx, context0.ContextKeyCurrentMethod)
+	if !exist {
+		currentMethod = callerMethod()
+	}
+	context0.Set(ctx, context0.ContextKeyUpstreamMethod, currentMethod)
+
+	currentService, exist := context0.Get(ctx, context0.ContextKeyCurrentService)
+	if !exist {
+		currentService = processName
+	}
+	context0.Set(ctx, context0.ContextKeyUpstreamService, currentService)
+	context0.Del(ctx, context0.ContextKeyUpstreamAddress)
+
+	// RoundTrip must not modify the caller's request
+	reqTemp := req.Clone(req.Context())
+	context0.ToHttpHeader(ctx, reqTemp.Header)
+
+	downstreamService := req.URL.Host
+	downstreamMethod := req.Method + " " + req.URL.Path
+
+	now := time.Now()
+	resp, err := t.Base.RoundTrip(reqTemp)
+	cost := time.Since(now).Milliseconds()
+
+	if err != nil {
+		log.Infof(ctx, "【request】upstreamService:%v upstreamMethod:%v downstreamService:%v downstreamMethod:%v currentService:%v currentMethod:%v cost:%v(ms) req:%s, err:%v",
+			upstreamService, upstreamMethod, downstreamService, downstreamMethod, currentService, currentMethod, cost, logURL(req.URL), err)
+		return resp, err
+	}
+
+	log.Infof(ctx, "【request】upstreamService:%v upstreamMethod:%v downstreamService:%v downstreamMethod:%v currentService:%v currentMethod:%v cost:%v(ms) req:%s, resp:%s(%d bytes)",
+		upstreamService, upstreamMethod, downstreamService, downstreamMethod, currentService, currentMethod, cost, logURL(req.URL), resp.Status, resp.ContentLength)
+
+	return resp, nil
+}
+
+// logURL drops the user info, query and fragment of a request url, they may carry credentials
+func logURL(u *url.URL) string {
+	redacted := url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path, RawPath: u.RawPath}
+	return redacted.String()
+}
+
+// callerMethod names the first function outside net/http and this package, like the grpc client interceptor does
+func callerMethod() string {
+	pc := make([]uintptr, 32)
+	count := runtime.Callers(3, pc)
+	frames := runtime.CallersFrames(pc[:count])
+	for {
+		frame, more := frames.Next()
+		if !isInternalFrame(frame.Function) {
+			return fmt.Sprintf("<local>-<%s>", frame.Function)
+		}
+
+		if !more {
+			return fmt.Sprintf("<local>-<%s>", frame.Function)
+		}
+	}
+}
+
+func isInternalFrame(function string) bool {
+	for _, prefix := range []string{"net/http.", "github.com/RealJonathanYip/framework/http_client."} {
+		if len(function) >= len(prefix) && function[:len(prefix)] == prefix {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,317 @@
+package http_server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/RealJonathanYip/framework/log"
+	"io"
+	"math/rand"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const _REDACTED = "***"
+
+type bodyLogConf struct {
+	maxSize        int
+	redactFields   map[string]bool
+	redactHeaders  map[string]bool
+	sampleRate     float64
+	debugHeader    string
+	fieldsReplacer *regexp.Regexp
+}
+
+type bodyLogOption interface {
+	apply(*bodyLogConf)
+}
+
+type bodyLogOptionFunc func(*bodyLogConf)
+
+func (f bodyLogOptionFunc) apply(conf *bodyLogConf) {
+	f(conf)
+}
+
+// BodyLogMaxSize caps the bytes logged of each body, default 4KB
+func BodyLogMaxSize(size int) bodyLogOption {
+	return bodyLogOptionFunc(func(conf *bodyLogConf) {
+		conf.maxSize = size
+	})
+}
+
+// BodyLogRedactFields masks json fields with these names at any depth, form fields and query parameters,
+// case insensitive
+func BodyLogRedactFields(fields ...string) bodyLogOption {
+	return bodyLogOptionFunc(func(conf *bodyLogConf) {
+		for _, field := range fields {
+			conf.redactFields[strings.ToLower(field)] = true
+		}
+	})
+}
+
+// BodyLogRedactHeaders masks headers, Authorization, Cookie and Set-Cookie are always masked
+func BodyLogRedactHeaders(headers ...string) bodyLogOption {
+	return bodyLogOptionFunc(func(conf *bodyLogConf) {
+		for _, header := range headers {
+			conf.redactHeaders[http.CanonicalHeaderKey(header)] = true
+		}
+	})
+}
+
+// BodyLogSampleRate logs percent (0-100) of the requests, default 100
+func BodyLogSampleRate(percent float64) bodyLogOption {
+	return bodyLogOptionFunc(func(conf *bodyLogConf) {
+		conf.sampleRate = percent
+	})
+}
+
+// BodyLogDebugHeader forces logging when the request carries this header with a true value, default X-Debug-Log,
+// it is honoured only for requests from trusted proxies or allowed by Request.AllowDebugLog
+func BodyLogDebugHeader(name string) bodyLogOption {
+	return bodyLogOptionFunc(func(conf *bodyLogConf) {
+		conf.debugHeader = name
+	})
+}
+
+// BodyLog logs request and response bodies with headers through the framework logger
+//
+// Example: server.Use(BodyLog(BodyLogRedactFields("password", "id_card"), BodyLogSampleRate(1)))
+func BodyLog(opts ...bodyLogOption) Middleware {
+	conf := &bodyLogConf{
+		maxSize:      4 << 10,
+		redactFields: make(map[string]bool),
+		redactHeaders: map[string]bool{
+			"Authorization": true,
+			"Cookie":        true,
+			"Set-Cookie":    true,
+		},
+		sampleRate:  100,
+		debugHeader: "X-Debug-Log",
+	}
+	for _, opt := range opts {
+		opt.apply(conf)
+	}
+
+	conf.compile()
+
+	return func(handler func(context.Context, *Response, *Request)) func(context.Context, *Response, *Request) {
+		return func(ctx context.Context, resp *Response, req *Request) {
+			// the debug header is checked after the handler, an auth middleware inside may allow it
+			sampled, debug := conf.sampled(), conf.debugRequested(req)
+			if !sampled && !debug {
+				handler(ctx, resp, req)
+				return
+			}
+
+			reqBody, reqTruncated := conf.captureRequest(req)
+
+			writer := resp.ResponseWriter
+			recorder := newResponseRecorder(writer, conf.maxSize, true)
+			resp.ResponseWriter = recorder
+
+			now := time.Now()
+			handler(ctx, resp, req)
+			cost := time.Since(now).Milliseconds()
+			resp.ResponseWriter = writer
+
+			if !sampled && !req.debugAllowed() {
+				return
+			}
+
+			log.Infof(ctx, "【body】method:%s uri:%s status:%d cost:%v(ms) reqHeader:%s req:%s respHeader:%s resp:%s",
+				req.Method, conf.uriText(req.URL), recorder.status, cost,
+				conf.headerText(req.Header), conf.bodyText(req.Header.Get("Content-Type"), reqBody, reqTruncated),
+				conf.headerText(writer.Header()), conf.bodyText(writer.Header().Get("Content-Type"), recorder.body.Bytes(), recorder.truncated))
+		}
+	}
+}
+
+// compile builds the replacer masking redactFields in bodies which are not valid json, e.g. truncated
+func (c *bodyLogConf) compile() {
+	if len(c.redactFields) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(c.redactFields))
+	for field := range c.redactFields {
+		names = append(names, regexp.QuoteMeta(field))
+	}
+	c.fieldsReplacer = regexp.MustCompile(`(?i)("(?:` + strings.Join(names, "|") + `)"\s*:\s*)("(?:[^"\\]|\\.)*"?|[^,}\]\s]*)`)
+}
+
+func (c *bodyLogConf) sampled() bool {
+	return c.sampleRate >= 100 || rand.Float64()*100 < c.sampleRate
+}
+
+func (c *bodyLogConf) debugRequested(req *Request) bool {
+	value := strings.ToLower(req.Header.Get(c.debugHeader))
+	return value == "1" || value == "true"
+}
+
+// AllowDebugLog lets the debug header of BodyLog force logging this request,
+// auth middlewares call it for the users allowed to, requests from trusted proxies are always allowed
+func (r *Request) AllowDebugLog() {
+	r.debugLog = true
+}
+
+func (r *Request) debugAllowed() bool {
+	if r.debugLog {
+		return true
+	}
+
+	remoteIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remoteIP = r.RemoteAddr
+	}
+	return r.server != nil && r.server.isTrustedProxy(remoteIP)
+}
+
+// uriText masks the query parameters named in redactFields
+func (c *bodyLogConf) uriText(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.EscapedPath()
+	}
+
+	return u.EscapedPath() + "?" + c.formText(u.RawQuery)
+}
+
+// captureRequest reads the first maxSize bytes of the body and puts them back for the handler
+func (c *bodyLogConf) captureRequest(req *Request) ([]byte, bool) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, false
+	}
+
+	buffer := make([]byte, c.maxSize+1)
+	n, err := io.ReadFull(req.Body, buffer)
+	buffer = buffer[:n]
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		req.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(buffer), errReader{err}), Closer: req.Body}
+		return buffer, false
+	}
+
+	req.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(buffer), req.Body), Closer: req.Body}
+	if n > c.maxSize {
+		return buffer[:c.maxSize], true
+	}
+
+	return buffer, false
+}
+
+func (c *bodyLogConf) headerText(header http.Header) string {
+	masked := make(http.Header, len(header))
+	for key, values := range header {
+		if c.redactHeaders[http.CanonicalHeaderKey(key)] {
+			masked[key] = []string{_REDACTED}
+			continue
+		}
+		masked[key] = values
+	}
+
+	return fmt.Sprintf("%v", masked)
+}
+
+// bodyText redacts a text body, other content types like images are logged by their size only
+func (c *bodyLogConf) bodyText(contentType string, body []byte, truncated bool) string {
+	if len(body) == 0 {
+		return ""
+	}
+
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if !isTextMedia(mediaType) {
+		if truncated {
+			return fmt.Sprintf("<%s over %d bytes>", mediaType, c.maxSize)
+		}
+		return fmt.Sprintf("<%s %d bytes>", mediaType, len(body))
+	}
+
+	text := string(body)
+	if mediaType == "application/x-www-form-urlencoded" {
+		text = c.formText(text)
+	} else if c.fieldsReplacer != nil {
+		var value interface{}
+		if !truncated && json.Unmarshal(body, &value) == nil {
+			if data, err := json.Marshal(c.redact(value)); err == nil {
+				text = string(data)
+			}
+		} else {
+			text = c.fieldsReplacer.ReplaceAllString(text, `${1}"`+_REDACTED+`"`)
+		}
+	}
+
+	if truncated {
+		text += fmt.Sprintf("...(truncated at %d bytes)", c.maxSize)
+	}
+
+	return text
+}
+
+// formText masks the form fields pair by pair so a truncated form keeps its order and escaping
+func (c *bodyLogConf) formText(text string) string {
+	if len(c.redactFields) == 0 {
+		return text
+	}
+
+	pairs := strings.Split(text, "&")
+	for i, pair := range pairs {
+		rawKey := strings.SplitN(pair, "=", 2)[0]
+		key, err := url.QueryUnescape(rawKey)
+		if err != nil {
+			key = rawKey
+		}
+		if c.redactFields[strings.ToLower(key)] {
+			pairs[i] = rawKey + "=" + _REDACTED
+		}
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+func isTextMedia(mediaType string) bool {
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript", "application/x-www-form-urlencoded":
+		return true
+	}
+
+	return strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
+
+func (c *bodyLogConf) redact(value interface{}) interface{} {
+	switch valueTemp := value.(type) {
+	case map[string]interface{}:
+		for key, field := range valueTemp {
+			if c.redactFields[strings.ToLower(key)] {
+				valueTemp[key] = _REDACTED
+			} else {
+				valueTemp[key] = c.redact(field)
+			}
+		}
+	case []interface{}:
+		for i, item := range valueTemp {
+			valueTemp[i] = c.redact(item)
+		}
+	}
+
+	return value
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
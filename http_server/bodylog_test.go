@@ -0,0 +1,221 @@
+package http_server
+
+import (
+	"context"
+	"github.com/RealJonathanYip/framework/log"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type testLogWatcher struct {
+	lock  sync.Mutex
+	lines []string
+}
+
+var (
+	_testLogs    = &testLogWatcher{}
+	testLogsOnce sync.Once
+)
+
+func (w *testLogWatcher) OnMessage(level, msg string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.lines = append(w.lines, msg)
+}
+
+// captureLogs returns the messages containing text logged after it is called
+func captureLogs(text string) func() []string {
+	testLogsOnce.Do(func() {
+		log.AddWatcher(_testLogs)
+	})
+
+	_testLogs.lock.Lock()
+	start := len(_testLogs.lines)
+	_testLogs.lock.Unlock()
+
+	return func() []string {
+		_testLogs.lock.Lock()
+		defer _testLogs.lock.Unlock()
+
+		lines := make([]string, 0)
+		for _, line := range _testLogs.lines[start:] {
+			if strings.Contains(line, text) {
+				lines = append(lines, line)
+			}
+		}
+		return lines
+	}
+}
+
+func newBodyLogServer(opts ...bodyLogOption) (*HttpServer, *string) {
+	received := new(string)
+	server := New("test")
+	server.Post("/users", Chain(func(ctx context.Context, resp *Response, req *Request) {
+		data, _ := io.ReadAll(req.Body)
+		*received = string(data)
+		resp.Header().Set("Set-Cookie", "session=s1")
+		_ = resp.ReplyJson(ctx, map[string]string{"token": "t1", "name": "alice"})
+	}, BodyLog(opts...)), nil)
+
+	return server, received
+}
+
+func postUser(server *HttpServer, body string, header ...string) {
+	req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	server.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestBodyLog(t *testing.T) {
+	server, received := newBodyLogServer(BodyLogRedactFields("Password", "token"))
+	logs := captureLogs("【body】")
+
+	postUser(server, `{"name":"alice","password":"secret"}`)
+
+	if *received != `{"name":"alice","password":"secret"}` {
+		t.Fatalf("handler got body %q", *received)
+	}
+
+	lines := logs()
+	if len(lines) != 1 {
+		t.Fatalf("got %d body logs", len(lines))
+	}
+	for _, want := range []string{`req:{"name":"alice","password":"***"}`, `resp:{"name":"alice","token":"***"}`,
+		"Authorization:[***]", "Set-Cookie:[***]", "uri:/users status:200"} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("log %q has no %q", lines[0], want)
+		}
+	}
+	if strings.Contains(lines[0], "secret") || strings.Contains(lines[0], "s1") {
+		t.Errorf("log %q leaks a secret", lines[0])
+	}
+}
+
+func TestBodyLogTruncated(t *testing.T) {
+	server, received := newBodyLogServer(BodyLogMaxSize(8))
+	logs := captureLogs("【body】")
+
+	postUser(server, `{"name":"alice"}`)
+
+	if *received != `{"name":"alice"}` {
+		t.Fatalf("handler got body %q, the logged part was not put back", *received)
+	}
+	if lines := logs(); len(lines) != 1 || !strings.Contains(lines[0], `req:{"name":...(truncated at 8 bytes)`) {
+		t.Fatalf("got logs %q", lines)
+	}
+}
+
+func TestBodyLogSampling(t *testing.T) {
+	server, _ := newBodyLogServer(BodyLogSampleRate(0))
+	logs := captureLogs("【body】")
+
+	postUser(server, "{}")
+	if lines := logs(); len(lines) != 0 {
+		t.Fatalf("sample rate 0 logged %q", lines)
+	}
+
+	postUser(server, "{}", "X-Debug-Log", "true")
+	if lines := logs(); len(lines) != 0 {
+		t.Fatalf("debug header of an untrusted client logged %q", lines)
+	}
+
+	// httptest requests come from 192.0.2.1
+	if err := server.TrustProxies("192.0.2.0/24"); err != nil {
+		t.Fatalf("TrustProxies err:%v", err)
+	}
+	postUser(server, "{}", "X-Debug-Log", "true")
+	if lines := logs(); len(lines) != 1 {
+		t.Fatalf("debug header from a trusted proxy got %d logs", len(lines))
+	}
+}
+
+func TestBodyLogDebugAllowedByAuth(t *testing.T) {
+	server := New("test")
+	server.Post("/users", Chain(func(ctx context.Context, resp *Response, req *Request) {
+		_, _ = resp.Write([]byte("ok"))
+	}, BodyLog(BodyLogSampleRate(0)), func(next func(context.Context, *Response, *Request)) func(context.Context, *Response, *Request) {
+		return func(ctx context.Context, resp *Response, req *Request) {
+			if req.Header.Get("Authorization") == "Bearer admin" {
+				req.AllowDebugLog()
+			}
+			next(ctx, resp, req)
+		}
+	}), nil)
+	logs := captureLogs("【body】")
+
+	postUser(server, "{}", "X-Debug-Log", "true")
+	if lines := logs(); len(lines) != 0 {
+		t.Fatalf("debug header of a user not allowed logged %q", lines)
+	}
+
+	postUser(server, "{}", "X-Debug-Log", "true", "Authorization", "Bearer admin")
+	if lines := logs(); len(lines) != 1 {
+		t.Fatalf("debug header allowed by auth got %d logs", len(lines))
+	}
+}
+
+func TestBodyLogRedactQuery(t *testing.T) {
+	server := New("test")
+	server.Get("/users", Chain(replyText("ok"), BodyLog(BodyLogRedactFields("token"))), nil)
+	logs := captureLogs("【body】")
+
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users?page=2&Token=secret", nil))
+	if lines := logs(); len(lines) != 1 || !strings.Contains(lines[0], "uri:/users?page=2&Token=*** ") {
+		t.Fatalf("got logs %q", lines)
+	}
+}
+
+func TestBodyLogRedactTruncatedJson(t *testing.T) {
+	server, _ := newBodyLogServer(BodyLogMaxSize(26), BodyLogRedactFields("password"))
+	logs := captureLogs("【body】")
+
+	postUser(server, `{"name":"a","password":"secret"}`)
+
+	lines := logs()
+	if len(lines) != 1 || !strings.Contains(lines[0], `req:{"name":"a","password":"***"...(truncated at 26 bytes)`) {
+		t.Fatalf("got logs %q", lines)
+	}
+	if strings.Contains(lines[0], `"se`) {
+		t.Fatalf("log %q leaks the start of the password", lines[0])
+	}
+}
+
+func TestBodyLogForm(t *testing.T) {
+	server, _ := newBodyLogServer(BodyLogRedactFields("password"))
+	logs := captureLogs("【body】")
+
+	postUser(server, "name=alice&Pass%77ord=secret&x=1", "Content-Type", "application/x-www-form-urlencoded")
+
+	lines := logs()
+	if len(lines) != 1 || !strings.Contains(lines[0], "req:name=alice&Pass%77ord=***&x=1 ") {
+		t.Fatalf("got logs %q", lines)
+	}
+}
+
+func TestBodyLogBinary(t *testing.T) {
+	server, received := newBodyLogServer(BodyLogMaxSize(4))
+	logs := captureLogs("【body】")
+
+	postUser(server, "\x89PNG\r\n\x1a\n", "Content-Type", "image/png")
+	if *received != "\x89PNG\r\n\x1a\n" {
+		t.Fatalf("handler got body %q", *received)
+	}
+	if lines := logs(); len(lines) != 1 || !strings.Contains(lines[0], "req:<image/png over 4 bytes>") {
+		t.Fatalf("got logs %q", lines)
+	}
+
+	// without a content type the body is sniffed
+	server, _ = newBodyLogServer()
+	postUser(server, "\x00\x01\x02")
+	if lines := logs(); len(lines) != 2 || !strings.Contains(lines[1], "req:<application/octet-stream 3 bytes>") {
+		t.Fatalf("got logs %q", lines)
+	}
+}
@@ -0,0 +1,230 @@
+package http_server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type cacheEntry struct {
+	status   int
+	header   http.Header
+	body     []byte
+	expireAt time.Time
+}
+
+// cacheHeaders are the representation headers replayed on a HIT, others like Set-Cookie or
+// X-Trace-Id belong to the request which filled the cache
+var cacheHeaders = []string{"Content-Type", "Content-Encoding", "Content-Language", "ETag", "Last-Modified", "Cache-Control", "Vary"}
+
+// CacheVary is a part of the cache key, Header is also sent in the Vary response header
+type CacheVary struct {
+	Header string
+	Key    func(*Request) string
+}
+
+type responseCache struct {
+	ttl       time.Duration
+	varyBy    []CacheVary
+	vary      []string
+	lock      sync.RWMutex
+	entries   map[string]*cacheEntry
+	lastSweep time.Time
+}
+
+// ResponseCache caches successful GET/HEAD replies of a route in memory for ttl,
+// the cache key is method + host + path + query plus the values returned by varyBy,
+// replies setting cookies or sending Cache-Control no-store or private are never cached
+//
+// Example: server.Get("/config", Chain(handler, ResponseCache(time.Minute, VaryByHeader("Accept-Language"))), nil)
+func ResponseCache(ttl time.Duration, varyBy ...CacheVary) Middleware {
+	cache := &responseCache{
+		ttl:       ttl,
+		varyBy:    varyBy,
+		entries:   make(map[string]*cacheEntry),
+		lastSweep: time.Now(),
+	}
+	for _, vary := range varyBy {
+		if vary.Header != "" {
+			cache.vary = append(cache.vary, http.CanonicalHeaderKey(vary.Header))
+		}
+	}
+
+	return func(handler func(context.Context, *Response, *Request)) func(context.Context, *Response, *Request) {
+		return func(ctx context.Context, resp *Response, req *Request) {
+			if req.Method != _METHOD_GET && req.Method != _METHOD_HEAD {
+				handler(ctx, resp, req)
+				return
+			}
+
+			key := cache.key(req)
+			if entry := cache.get(key); entry != nil {
+				cache.reply(ctx, resp, req, entry, "HIT")
+				return
+			}
+
+			entry, cacheable := cache.fill(ctx, handler, resp, req)
+			if entry.status == http.StatusOK && cacheable {
+				cache.set(key, entry)
+			}
+
+			cache.reply(ctx, resp, req, entry, "MISS")
+		}
+	}
+}
+
+func VaryByHeader(name string) CacheVary {
+	return CacheVary{
+		Header: name,
+		Key: func(req *Request) string {
+			return name + "=" + req.Header.Get(name)
+		},
+	}
+}
+
+func VaryByQuery(name string) CacheVary {
+	return CacheVary{
+		Key: func(req *Request) string {
+			return name + "=" + req.URL.Query().Get(name)
+		},
+	}
+}
+
+func (c *responseCache) key(req *Request) string {
+	builder := strings.Builder{}
+	builder.WriteString(req.Method)
+	builder.WriteString(" ")
+	builder.WriteString(strings.ToLower(req.Host))
+	builder.WriteString(req.URL.Path)
+	builder.WriteString("?")
+	builder.WriteString(req.URL.RawQuery)
+	for _, vary := range c.varyBy {
+		builder.WriteString("|")
+		builder.WriteString(vary.Key(req))
+	}
+
+	return builder.String()
+}
+
+func (c *responseCache) get(key string) *cacheEntry {
+	c.lock.RLock()
+	entry, exist := c.entries[key]
+	c.lock.RUnlock()
+
+	if !exist || time.Now().After(entry.expireAt) {
+		return nil
+	}
+
+	return entry
+}
+
+func (c *responseCache) set(key string, entry *cacheEntry) {
+	now := time.Now()
+	entry.expireAt = now.Add(c.ttl)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.entries[key] = entry
+	if now.Sub(c.lastSweep) < c.ttl {
+		return
+	}
+
+	c.lastSweep = now
+	for keyTemp, entryTemp := range c.entries {
+		if now.After(entryTemp.expireAt) {
+			delete(c.entries, keyTemp)
+		}
+	}
+}
+
+// fill runs the handler against a recorder without preconditions so a complete reply can be cached,
+// it is not cacheable when the handler sets a cookie or forbids shared caching
+func (c *responseCache) fill(ctx context.Context, handler func(context.Context, *Response, *Request), resp *Response,
+	req *Request) (*cacheEntry, bool) {
+	ifNoneMatch, ifModifiedSince := req.Header.Get("If-None-Match"), req.Header.Get("If-Modified-Since")
+	req.Header.Del("If-None-Match")
+	req.Header.Del("If-Modified-Since")
+	defer func() {
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		if ifModifiedSince != "" {
+			req.Header.Set("If-Modified-Since", ifModifiedSince)
+		}
+	}()
+
+	writer := resp.ResponseWriter
+	recorder := newResponseRecorder(writer, -1, false)
+	resp.ResponseWriter = recorder
+	handler(ctx, resp, req)
+	resp.ResponseWriter = writer
+
+	header := make(http.Header)
+	for _, key := range cacheHeaders {
+		if values := writer.Header().Values(key); len(values) > 0 {
+			header[http.CanonicalHeaderKey(key)] = append([]string(nil), values...)
+		}
+	}
+
+	entry := &cacheEntry{
+		status: recorder.status,
+		header: header,
+		body:   recorder.body.Bytes(),
+	}
+
+	return entry, len(writer.Header().Values("Set-Cookie")) == 0 && !privateReply(writer.Header())
+}
+
+// privateReply reports whether Cache-Control has no-store or private, the reply is only for its own client
+func privateReply(header http.Header) bool {
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name := strings.ToLower(strings.TrimSpace(strings.SplitN(directive, "=", 2)[0]))
+			if name == "no-store" || name == "private" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (c *responseCache) reply(ctx context.Context, resp *Response, req *Request, entry *cacheEntry, state string) {
+	header := resp.Header()
+	for key, values := range entry.header {
+		header[key] = append([]string(nil), values...)
+	}
+	for _, vary := range c.vary {
+		addVary(header, vary)
+	}
+	header.Set("X-Cache", state)
+	if state == "HIT" {
+		resp.onBeforeReply(ctx, resp)
+	}
+
+	if entry.status == http.StatusOK && checkNotModified(req.Request, header) {
+		header.Del("content-type")
+		resp.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	resp.WriteHeader(entry.status)
+	if req.Method != _METHOD_HEAD {
+		_, _ = resp.Write(entry.body)
+	}
+}
+
+func addVary(header http.Header, name string) {
+	for _, value := range header.Values("Vary") {
+		for _, exist := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(exist), name) {
+				return
+			}
+		}
+	}
+
+	header.Add("Vary", name)
+}
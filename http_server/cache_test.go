@@ -0,0 +1,221 @@
+package http_server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReplyJsonNotModified(t *testing.T) {
+	server := New("test")
+	server.Get("/etag", func(ctx context.Context, resp *Response, req *Request) {
+		_ = resp.ReplyJson(ctx, map[string]int{"a": 1})
+	}, nil)
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/etag", nil))
+	etag := recorder.Header().Get("ETag")
+	if recorder.Code != http.StatusOK || etag == "" {
+		t.Fatalf("got status %d etag %q", recorder.Code, etag)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/etag", nil)
+	req.Header.Set("If-None-Match", "\"other\", "+etag)
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusNotModified || recorder.Body.Len() != 0 {
+		t.Fatalf("got status %d body %q, want 304 without body", recorder.Code, recorder.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/etag", nil)
+	req.Header.Set("If-None-Match", "\"other\"")
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d for a stale etag, want 200", recorder.Code)
+	}
+}
+
+func TestCheckNotModifiedByLastModified(t *testing.T) {
+	modTime := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	server := New("test")
+	server.Get("/modified", func(ctx context.Context, resp *Response, req *Request) {
+		resp.SetLastModified(modTime)
+		if resp.CheckNotModified(ctx) {
+			return
+		}
+		_, _ = resp.Write([]byte("body"))
+	}, nil)
+
+	for since, want := range map[time.Time]int{
+		modTime:                   http.StatusNotModified,
+		modTime.Add(time.Hour):    http.StatusNotModified,
+		modTime.Add(-time.Second): http.StatusOK,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/modified", nil)
+		req.Header.Set("If-Modified-Since", since.Format(http.TimeFormat))
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+
+		if recorder.Code != want {
+			t.Errorf("If-Modified-Since %v got status %d, want %d", since, recorder.Code, want)
+		}
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	calls := 0
+	server := New("test")
+	server.Get("/cache", Chain(func(ctx context.Context, resp *Response, req *Request) {
+		calls++
+		_ = resp.ReplyJson(ctx, req.URL.Query().Get("q"))
+	}, ResponseCache(time.Minute)), nil)
+
+	serve := func(uri string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, uri, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	first := serve("/cache?q=a")
+	if got := first.Header().Get("X-Cache"); got != "MISS" || first.Body.String() != `"a"` {
+		t.Fatalf("first reply X-Cache %q body %q", got, first.Body.String())
+	}
+
+	second := serve("/cache?q=a")
+	if got := second.Header().Get("X-Cache"); got != "HIT" || second.Body.String() != `"a"` || calls != 1 {
+		t.Fatalf("second reply X-Cache %q body %q after %d calls", got, second.Body.String(), calls)
+	}
+
+	if got := serve("/cache?q=b").Header().Get("X-Cache"); got != "MISS" {
+		t.Fatalf("other query X-Cache %q, want MISS", got)
+	}
+
+	notModified := serve("/cache?q=a", "If-None-Match", first.Header().Get("ETag"))
+	if notModified.Code != http.StatusNotModified || calls != 2 {
+		t.Fatalf("got status %d after %d calls, want 304 from the cache", notModified.Code, calls)
+	}
+}
+
+func TestResponseCacheReplaysRepresentationHeaders(t *testing.T) {
+	server := New("test")
+	server.Get("/cache", Chain(func(ctx context.Context, resp *Response, req *Request) {
+		resp.Header().Set("X-Session", "user-1")
+		resp.Header().Set("Content-Language", "en")
+		_ = resp.ReplyJson(ctx, "ok")
+	}, ResponseCache(time.Minute)), nil)
+
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/cache", nil))
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/cache", nil))
+
+	header := recorder.Header()
+	if header.Get("X-Cache") != "HIT" || header.Get("Content-Type") != "application/json; charset=utf-8" || header.Get("Content-Language") != "en" {
+		t.Fatalf("got header %v", header)
+	}
+	if header.Get("X-Session") != "" {
+		t.Fatalf("X-Session of the first request replayed: %v", header)
+	}
+}
+
+func TestResponseCacheSkipsCookies(t *testing.T) {
+	calls := 0
+	server := New("test")
+	server.Get("/cache", Chain(func(ctx context.Context, resp *Response, req *Request) {
+		calls++
+		http.SetCookie(resp, &http.Cookie{Name: "session", Value: "user-1"})
+		_ = resp.ReplyJson(ctx, "ok")
+	}, ResponseCache(time.Minute)), nil)
+
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/cache", nil))
+		if got := recorder.Header().Get("X-Cache"); got != "MISS" {
+			t.Fatalf("request %d X-Cache %q", i, got)
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("handler called %d times, want every reply with a cookie uncached", calls)
+	}
+}
+
+func TestResponseCacheVary(t *testing.T) {
+	server := New("test")
+	server.Get("/cache", Chain(func(ctx context.Context, resp *Response, req *Request) {
+		resp.Header().Set("Vary", "Accept-Language")
+		_ = resp.ReplyJson(ctx, req.Header.Get("Accept-Language"))
+	}, ResponseCache(time.Minute, VaryByHeader("accept-language"), VaryByQuery("page"))), nil)
+
+	serve := func(lang string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/cache", nil)
+		req.Header.Set("Accept-Language", lang)
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	serve("en")
+	hit := serve("en")
+	if hit.Header().Get("X-Cache") != "HIT" || hit.Body.String() != `"en"` {
+		t.Fatalf("same language got X-Cache %q body %q", hit.Header().Get("X-Cache"), hit.Body.String())
+	}
+	if vary := hit.Header().Values("Vary"); len(vary) != 1 || vary[0] != "Accept-Language" {
+		t.Fatalf("got Vary %v, want Accept-Language once", vary)
+	}
+
+	if miss := serve("zh"); miss.Header().Get("X-Cache") != "MISS" || miss.Body.String() != `"zh"` {
+		t.Fatalf("other language got X-Cache %q body %q", miss.Header().Get("X-Cache"), miss.Body.String())
+	}
+}
+
+func TestResponseCacheSkipsPrivateReplies(t *testing.T) {
+	calls := 0
+	server := New("test")
+	server.Get("/cache", Chain(func(ctx context.Context, resp *Response, req *Request) {
+		calls++
+		resp.Header().Set("Cache-Control", req.URL.Query().Get("cc"))
+		_ = resp.ReplyJson(ctx, "ok")
+	}, ResponseCache(time.Minute)), nil)
+
+	for _, cacheControl := range []string{"no-store", "max-age=60,%20PRIVATE", "private=%22X-User%22"} {
+		for i := 0; i < 2; i++ {
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/cache?cc="+cacheControl, nil))
+			if got := recorder.Header().Get("X-Cache"); got != "MISS" {
+				t.Fatalf("Cache-Control %s request %d X-Cache %q", cacheControl, i, got)
+			}
+		}
+	}
+	if calls != 6 {
+		t.Fatalf("handler called %d times, want every private reply uncached", calls)
+	}
+}
+
+func TestResponseCacheKeyHasHost(t *testing.T) {
+	server := New("test")
+	server.Get("/cache", Chain(func(ctx context.Context, resp *Response, req *Request) {
+		_ = resp.ReplyJson(ctx, req.Host)
+	}, ResponseCache(time.Minute)), nil)
+
+	serve := func(host string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/cache", nil)
+		req.Host = host
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	serve("a.example.com")
+	if hit := serve("A.example.com"); hit.Header().Get("X-Cache") != "HIT" || hit.Body.String() != `"a.example.com"` {
+		t.Fatalf("same host got X-Cache %q body %q", hit.Header().Get("X-Cache"), hit.Body.String())
+	}
+	if miss := serve("b.example.com"); miss.Header().Get("X-Cache") != "MISS" || miss.Body.String() != `"b.example.com"` {
+		t.Fatalf("other host got X-Cache %q body %q", miss.Header().Get("X-Cache"), miss.Body.String())
+	}
+}
@@ -0,0 +1,94 @@
+package http_server
+
+import (
+	"context"
+	"github.com/RealJonathanYip/framework/log"
+	"sync/atomic"
+	"time"
+)
+
+type concurrencyLimiter struct {
+	slots        chan struct{}
+	waiting      int32
+	maxQueue     int32
+	queueTimeout time.Duration
+}
+
+// newConcurrencyLimiter returns nil for maxInFlight <= 0, which means no limit
+func newConcurrencyLimiter(maxInFlight, maxQueue int, queueTimeout time.Duration) *concurrencyLimiter {
+	if maxInFlight <= 0 {
+		return nil
+	}
+
+	return &concurrencyLimiter{
+		slots:        make(chan struct{}, maxInFlight),
+		maxQueue:     int32(maxQueue),
+		queueTimeout: queueTimeout,
+	}
+}
+
+// acquire takes a slot, waiting in the queue for at most queueTimeout when all slots are busy,
+// it gives up when the queue is full or the client goes away
+func (l *concurrencyLimiter) acquire(req *Request) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	if atomic.AddInt32(&l.waiting, 1) > l.maxQueue {
+		atomic.AddInt32(&l.waiting, -1)
+		return false
+	}
+	defer atomic.AddInt32(&l.waiting, -1)
+
+	var timeout <-chan time.Time
+	if l.queueTimeout > 0 {
+		timer := time.NewTimer(l.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timeout:
+		return false
+	case <-req.Context().Done():
+		return false
+	}
+}
+
+func (l *concurrencyLimiter) release() {
+	<-l.slots
+}
+
+// SetConcurrencyLimit limits the requests served at the same time by the whole server,
+// at most maxQueue requests wait up to queueTimeout (0 means until the client goes away) for a slot,
+// the others are replied by the overflow handler of their route, maxInFlight <= 0 removes the limit,
+// it must be called before Run
+func (h *HttpServer) SetConcurrencyLimit(maxInFlight, maxQueue int, queueTimeout time.Duration) {
+	h.concurrencyLimiter = newConcurrencyLimiter(maxInFlight, maxQueue, queueTimeout)
+}
+
+// ConcurrencyLimit is the per route version of SetConcurrencyLimit, apply it by Chain
+func ConcurrencyLimit(maxInFlight, maxQueue int, queueTimeout time.Duration) Middleware {
+	limiter := newConcurrencyLimiter(maxInFlight, maxQueue, queueTimeout)
+
+	return func(handler func(context.Context, *Response, *Request)) func(context.Context, *Response, *Request) {
+		if limiter == nil {
+			return handler
+		}
+
+		return func(ctx context.Context, resp *Response, req *Request) {
+			if !limiter.acquire(req) {
+				log.Warningf(ctx, "http route:%s concurrency over %d", req.URL.Path, maxInFlight)
+				req.OverFlow(ctx, resp)
+				return
+			}
+			defer limiter.release()
+
+			handler(ctx, resp, req)
+		}
+	}
+}
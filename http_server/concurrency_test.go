@@ -0,0 +1,114 @@
+package http_server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// holdingServer serves /hold?hold=1 until release is closed, other requests reply at once
+type holdingServer struct {
+	*HttpServer
+	started chan struct{}
+	release chan struct{}
+	group   sync.WaitGroup
+}
+
+func newHoldingServer(middlewares ...Middleware) *holdingServer {
+	server := &holdingServer{HttpServer: New("test"), started: make(chan struct{}, 16), release: make(chan struct{})}
+	server.Get("/hold", Chain(func(ctx context.Context, resp *Response, req *Request) {
+		if req.URL.Query().Get("hold") != "" {
+			server.started <- struct{}{}
+			<-server.release
+		}
+		_, _ = resp.Write([]byte("ok"))
+	}, middlewares...), func(ctx context.Context, resp *Response, req *Request) {
+		http.Error(resp, "busy", http.StatusTooManyRequests)
+	})
+
+	return server
+}
+
+// hold starts count requests which keep their slots until done
+func (s *holdingServer) hold(count int) {
+	for i := 0; i < count; i++ {
+		s.group.Add(1)
+		go func() {
+			defer s.group.Done()
+			s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/hold?hold=1", nil))
+		}()
+		<-s.started
+	}
+}
+
+func (s *holdingServer) done() {
+	close(s.release)
+	s.group.Wait()
+}
+
+func (s *holdingServer) get() int {
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/hold", nil))
+	return recorder.Code
+}
+
+func TestConcurrencyLimitRoute(t *testing.T) {
+	server := newHoldingServer(ConcurrencyLimit(2, 0, 0))
+	defer server.done()
+
+	server.hold(1)
+	if code := server.get(); code != http.StatusOK {
+		t.Fatalf("free slot got status %d", code)
+	}
+
+	server.hold(1)
+	if code := server.get(); code != http.StatusTooManyRequests {
+		t.Fatalf("busy route got status %d, want the overflow handler", code)
+	}
+}
+
+func TestConcurrencyLimitQueue(t *testing.T) {
+	server := newHoldingServer()
+	server.SetConcurrencyLimit(1, 1, 0)
+	server.hold(1)
+
+	queued := make(chan int)
+	go func() {
+		queued <- server.get()
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	if code := server.get(); code != http.StatusTooManyRequests {
+		t.Fatalf("request over the queue got status %d", code)
+	}
+
+	server.done()
+	if code := <-queued; code != http.StatusOK {
+		t.Fatalf("queued request got status %d after the slot was released", code)
+	}
+}
+
+func TestConcurrencyLimitQueueTimeout(t *testing.T) {
+	server := newHoldingServer(ConcurrencyLimit(1, 1, 10*time.Millisecond))
+	defer server.done()
+
+	server.hold(1)
+	now := time.Now()
+	if code := server.get(); code != http.StatusTooManyRequests || time.Since(now) < 10*time.Millisecond {
+		t.Fatalf("got status %d after %v, want to wait for the queue timeout", code, time.Since(now))
+	}
+}
+
+func TestConcurrencyLimitZero(t *testing.T) {
+	server := newHoldingServer(ConcurrencyLimit(0, 0, 0))
+	server.SetConcurrencyLimit(-1, 0, 0)
+	defer server.done()
+
+	server.hold(3)
+	if code := server.get(); code != http.StatusOK {
+		t.Fatalf("no limit got status %d", code)
+	}
+}
@@ -0,0 +1,131 @@
+package http_server
+
+import (
+	"context"
+	"github.com/RealJonathanYip/framework/context0"
+	"github.com/RealJonathanYip/framework/log"
+	"github.com/pkg/errors"
+	"io/fs"
+	"net"
+	"strings"
+)
+
+type listenerConf struct {
+	name        string
+	address     string
+	middlewares []Middleware
+	listener    net.Listener
+}
+
+// RouteGroup registers routes served only for a host and/or a listener, handlers and lifecycle stay shared
+//
+// Example: server.Listen("admin", "127.0.0.1:9000"); server.Listener("admin").Get("/metrics", metrics, nil)
+type RouteGroup struct {
+	server *HttpServer
+	scope  routeScope
+}
+
+// Listen adds a listener started by Run besides the default port, middlewares apply only to its requests,
+// it must be called before Run
+func (h *HttpServer) Listen(name, address string, middlewares ...Middleware) {
+	if name == DEFAULT_LISTENER {
+		log.Panicf(context0.NewContext(), "http listener name:%s is reserved", name)
+	}
+
+	for _, conf := range h.listeners {
+		if conf.name == name {
+			log.Panicf(context0.NewContext(), "http listener:%s exist!", name)
+		}
+	}
+
+	h.listeners = append(h.listeners, &listenerConf{name: name, address: address, middlewares: middlewares})
+}
+
+func (h *HttpServer) middlewaresOf(listener string) []Middleware {
+	for _, conf := range h.listeners {
+		if conf.name == listener && len(conf.middlewares) > 0 {
+			middlewares := make([]Middleware, 0, len(h.middlewares)+len(conf.middlewares))
+			return append(append(middlewares, h.middlewares...), conf.middlewares...)
+		}
+	}
+
+	return h.middlewares
+}
+
+// Host returns a group whose routes only match requests for host, "*.example.com" matches any sub domain
+func (h *HttpServer) Host(host string) *RouteGroup {
+	return &RouteGroup{server: h, scope: routeScope{host: strings.ToLower(host)}}
+}
+
+// Listener returns a group whose routes are only served on the named listener
+func (h *HttpServer) Listener(name string) *RouteGroup {
+	return &RouteGroup{server: h, scope: routeScope{listener: name}}
+}
+
+func (g *RouteGroup) Host(host string) *RouteGroup {
+	return &RouteGroup{server: g.server, scope: routeScope{host: strings.ToLower(host), listener: g.scope.listener}}
+}
+
+func (g *RouteGroup) Listener(name string) *RouteGroup {
+	return &RouteGroup{server: g.server, scope: routeScope{host: g.scope.host, listener: name}}
+}
+
+func (g *RouteGroup) Post(szPath string, fnHandler, fnOnOverFlow func(context.Context, *Response, *Request), maxQPS ...uint32) {
+	g.Handle(_METHOD_POST, szPath, fnHandler, fnOnOverFlow, maxQPS...)
+}
+
+func (g *RouteGroup) Put(szPath string, fnHandler, fnOnOverFlow func(context.Context, *Response, *Request), maxQPS ...uint32) {
+	g.Handle(_METHOD_PUT, szPath, fnHandler, fnOnOverFlow, maxQPS...)
+}
+
+func (g *RouteGroup) Get(szPath string, fnHandler, fnOnOverFlow func(context.Context, *Response, *Request), maxQPS ...uint32) {
+	g.Handle(_METHOD_GET, szPath, fnHandler, fnOnOverFlow, maxQPS...)
+}
+
+func (g *RouteGroup) Delete(szPath string, fnHandler, fnOnOverFlow func(context.Context, *Response, *Request), maxQPS ...uint32) {
+	g.Handle(_METHOD_DELETE, szPath, fnHandler, fnOnOverFlow, maxQPS...)
+}
+
+func (g *RouteGroup) Handle(szMethod, szPath string, fnHandler, fnOnOverFlow func(context.Context, *Response, *Request), maxQPS ...uint32) {
+	if err := g.server.doRegisterHttpHandler(g.scope, szPath, szMethod, fnHandler, fnOnOverFlow, maxQPS...); err != nil {
+		log.Panicf(context0.NewContext(), "%v", err)
+	}
+}
+
+func (g *RouteGroup) HandlePrefix(szMethod, szPrefix string, fnHandler, fnOnOverFlow func(context.Context, *Response, *Request), maxQPS ...uint32) {
+	if err := g.server.doRegisterPrefixHandler(g.scope, szPrefix, szMethod, fnHandler, fnOnOverFlow, maxQPS...); err != nil {
+		log.Panicf(context0.NewContext(), "%v", err)
+	}
+}
+
+func (g *RouteGroup) Static(prefix string, fsys fs.FS, opts ...staticOption) {
+	g.server.doStatic(g.scope, prefix, fsys, opts...)
+}
+
+func (g *RouteGroup) Remove(szMethod, szPath string) bool {
+	return g.server.doRemove(g.scope, szPath, szMethod, false)
+}
+
+func (g *RouteGroup) RemovePrefix(szMethod, szPrefix string) bool {
+	return g.server.doRemove(g.scope, szPrefix, szMethod, true)
+}
+
+var errListenerNotFound = errors.New("http listener not found")
+
+// ListenerAddr returns the bound address of a listener once Run has started it
+func (h *HttpServer) ListenerAddr(name string) (net.Addr, error) {
+	if name == DEFAULT_LISTENER {
+		if h.listener == nil {
+			return nil, errListenerNotFound
+		}
+		return h.listener.Addr(), nil
+	}
+
+	for _, conf := range h.listeners {
+		if conf.name == name && conf.listener != nil {
+			return conf.listener.Addr(), nil
+		}
+	}
+
+	return nil, errListenerNotFound
+}
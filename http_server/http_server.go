@@ -4,30 +4,48 @@ import (
 	"context"
 	"fmt"
 	"github.com/RealJonathanYip/framework/context0"
+	"github.com/RealJonathanYip/framework/discovery"
 	"github.com/RealJonathanYip/framework/log"
 	"github.com/RealJonathanYip/framework/overflow"
 	"github.com/RealJonathanYip/framework/utils"
 	"github.com/pkg/errors"
 	"net"
 	"net/http"
+	"sync"
+	"sync/atomic"
 )
 
 type HttpServer struct {
-	httpRouter      map[string]func(context.Context, *Response, *Request)
-	onBeforeRequest []func(context.Context, *Response, *Request) bool
-	onBeforeReply   []func(context.Context, *Response, *Request)
-	listener        net.Listener
-	port            int
-	name            string
+	routes             atomic.Value // *routeTable, replaced as a whole on every change
+	routerLock         sync.Mutex
+	onBeforeRequest    []func(context.Context, *Response, *Request) bool
+	onBeforeReply      []func(context.Context, *Response, *Request)
+	middlewares        []Middleware
+	trustedProxies     []*net.IPNet
+	concurrencyLimiter *concurrencyLimiter
+	messageCatalog     *MessageCatalog
+	versionDispatchers map[string]*versionDispatcher
+	versionLock        sync.Mutex
+	listeners          []*listenerConf
+	servers            []*http.Server
+	lifecycleLock      sync.Mutex
+	registry           discovery.Registry
+	heartbeat          *discovery.Heartbeat
+	listener           net.Listener
+	port               int
+	name               string
 }
 
 const (
 	ERROR_SERVICE_NOT_AVAILABLE = 502
 	ERROR_AUTH_ERROR            = 401
+	ERROR_TOO_MANY_REQUESTS     = 429
 	_METHOD_POST                = "POST"
 	_METHOD_GET                 = "GET"
 	_METHOD_DELETE              = "DELETE"
 	_METHOD_PUT                 = "PUT"
+	_METHOD_HEAD                = "HEAD"
+	DEFAULT_LISTENER            = "default"
 )
 
 type HttpResult uint32
@@ -40,107 +58,188 @@ type Reply struct {
 }
 
 func New(name string) *HttpServer {
-	return &HttpServer{
-		httpRouter:      make(map[string]func(context.Context, *Response, *Request)),
+	httpServer := &HttpServer{
 		onBeforeRequest: make([]func(context.Context, *Response, *Request) bool, 0),
 		onBeforeReply:   make([]func(context.Context, *Response, *Request), 0),
 		name:            "web." + name,
 	}
+	httpServer.routes.Store(newRouteTable())
+
+	return httpServer
 }
 
-func (h *HttpServer) onReq(rsp http.ResponseWriter, req *http.Request) {
+func (h *HttpServer) onReq(rsp http.ResponseWriter, req *http.Request, listener string) {
 	var path = req.URL.Path
 	var method = req.Method
 
+	// OPTIONS without its own route, e.g. a CORS preflight handler, is always allowed
 	if method == "OPTIONS" {
-		rsp.WriteHeader(200)
-		return
+		if _, exist := h.match(req.Host, listener, path, method); !exist {
+			rsp.WriteHeader(200)
+			return
+		}
 	}
-	ctx := context0.NewContext()
+	ctx := context0.FromHttpHeader(req.Header)
 	defer utils.Recover(ctx)
 
+	if traceID, exist := context0.Get(ctx, context0.ContextKeyTraceID); exist {
+		rsp.Header().Set(context0.HttpHeaderTraceID, traceID)
+	}
+
 	szEntryPoint := path + "_" + method
 	context0.Set(ctx, context0.ContextKeyCurrentService, h.name, context0.ContextKeyCurrentMethod, szEntryPoint)
 
-	if fnHandler, bExist := h.httpRouter[szEntryPoint]; !bExist {
+	if fnHandler, bExist := h.match(req.Host, listener, path, method); !bExist {
 		log.Warningf(ctx, "not found http -> %v", path+"_"+method)
 		http.NotFound(rsp, req)
 		return
 	} else {
-		request := &Request{req}
+		request := &Request{Request: req, server: h, listener: listener}
 		onBeforeReply := func(ctx context.Context, response *Response) {
 			for _, handler := range h.onBeforeReply {
 				handler(ctx, response, request)
 			}
 		}
-		response := &Response{rsp, onBeforeReply}
+		response := &Response{ResponseWriter: rsp, onBeforeReply: onBeforeReply, request: request}
 
 		fnHandler(ctx, response, request)
 	}
 }
 
-func (h *HttpServer) doRegisterHttpHandler(path, method string, handler, overFlowHandler func(context.Context, *Response, *Request), maxQPS ...uint32) {
+// ServeHTTP runs a request through hooks, overflow check, middlewares and router like Run does,
+// so the server can be mounted on another mux or driven in-memory by tests
+func (h *HttpServer) ServeHTTP(rsp http.ResponseWriter, req *http.Request) {
+	h.onReq(rsp, req, DEFAULT_LISTENER)
+}
+
+func (h *HttpServer) wrapHttpHandler(path, method string, handler, overFlowHandler func(context.Context, *Response, *Request), maxQPS ...uint32) func(context.Context, *Response, *Request) {
 	qps := uint32(10240)
 	if len(maxQPS) > 0 {
 		qps = maxQPS[0]
 	}
 
-	h.httpRouter[path+"_"+method] = func(ctx context.Context, resp *Response, req *Request) {
-		if overflow.IsOverFlow(method+"."+path, qps) {
+	return func(ctx context.Context, resp *Response, req *Request) {
+		req.overFlowHandler = overFlowHandler
+		if bOverFlow, _, resetIn := overflow.Take(method+"."+path, qps); bOverFlow {
 			if overFlowHandler != nil {
 				overFlowHandler(ctx, resp, req)
 				return
 			}
 
-			http.Error(resp, "uri over flow!  plase try again later", ERROR_SERVICE_NOT_AVAILABLE)
+			resp.Header().Set("Retry-After", retryAfterSeconds(resetIn))
+			http.Error(resp, "uri over flow!  plase try again later", ERROR_TOO_MANY_REQUESTS)
 			return
 		}
 
+		if h.concurrencyLimiter != nil {
+			if !h.concurrencyLimiter.acquire(req) {
+				log.Warningf(ctx, "http server:%s concurrency over %d", h.name, cap(h.concurrencyLimiter.slots))
+				req.OverFlow(ctx, resp)
+				return
+			}
+			defer h.concurrencyLimiter.release()
+		}
+
 		for _, fnHandler := range h.onBeforeRequest {
 			if interrupt := fnHandler(ctx, resp, req); interrupt {
 				return
 			}
 		}
 
-		handler(ctx, resp, req)
+		Chain(handler, h.middlewaresOf(req.listener)...)(ctx, resp, req)
 	}
-
-	log.Infof(context0.NewContext(), "register http router : %v", path+"_"+method)
 }
 
 func (h *HttpServer) Post(szPath string, fnHandler, fnOnOverFlow func(context.Context, *Response, *Request), maxQPS ...uint32) {
-	if _, bExist := h.httpRouter[_METHOD_POST+"."+szPath]; !bExist {
-		h.doRegisterHttpHandler(szPath, _METHOD_POST, fnHandler, fnOnOverFlow, maxQPS...)
-	} else {
-		log.Panicf(context0.NewContext(), "http uri:%s exist!", _METHOD_POST+"."+szPath)
-	}
+	h.Handle(_METHOD_POST, szPath, fnHandler, fnOnOverFlow, maxQPS...)
 }
 
 func (h *HttpServer) Put(szPath string, fnHandler, fnOnOverFlow func(context.Context, *Response, *Request), maxQPS ...uint32) {
-	if _, bExist := h.httpRouter[_METHOD_PUT+"."+szPath]; !bExist {
-		h.doRegisterHttpHandler(szPath, _METHOD_PUT, fnHandler, fnOnOverFlow, maxQPS...)
-	} else {
-		log.Panicf(context0.NewContext(), "http uri:%s exist!", _METHOD_PUT+"."+szPath)
-	}
+	h.Handle(_METHOD_PUT, szPath, fnHandler, fnOnOverFlow, maxQPS...)
 }
 
 func (h *HttpServer) Get(szPath string, fnHandler, fnOnOverFlow func(context.Context, *Response, *Request), maxQPS ...uint32) {
-	if _, bExist := h.httpRouter[_METHOD_GET+"."+szPath]; !bExist {
-		h.doRegisterHttpHandler(szPath, _METHOD_GET, fnHandler, fnOnOverFlow, maxQPS...)
-	} else {
-		log.Panicf(context0.NewContext(), "http uri:%s exist!", _METHOD_GET+"."+szPath)
-	}
+	h.Handle(_METHOD_GET, szPath, fnHandler, fnOnOverFlow, maxQPS...)
 }
 
 func (h *HttpServer) Delete(szPath string, fnHandler, fnOnOverFlow func(context.Context, *Response, *Request), maxQPS ...uint32) {
-	if _, bExist := h.httpRouter[_METHOD_DELETE+"."+szPath]; !bExist {
-		h.doRegisterHttpHandler(szPath, _METHOD_DELETE, fnHandler, fnOnOverFlow, maxQPS...)
-	} else {
-		log.Panicf(context0.NewContext(), "http uri:%s exist!", _METHOD_DELETE+"."+szPath)
+	h.Handle(_METHOD_DELETE, szPath, fnHandler, fnOnOverFlow, maxQPS...)
+}
+
+// Handle registers a route for any http method, e.g. PATCH, it is safe to call after Run
+func (h *HttpServer) Handle(szMethod, szPath string, fnHandler, fnOnOverFlow func(context.Context, *Response, *Request), maxQPS ...uint32) {
+	if err := h.doRegisterHttpHandler(routeScope{}, szPath, szMethod, fnHandler, fnOnOverFlow, maxQPS...); err != nil {
+		log.Panicf(context0.NewContext(), "%v", err)
+	}
+}
+
+// HandlePrefix registers a route matching every path starting with szPrefix, it is safe to call after Run
+func (h *HttpServer) HandlePrefix(szMethod, szPrefix string, fnHandler, fnOnOverFlow func(context.Context, *Response, *Request), maxQPS ...uint32) {
+	if err := h.doRegisterPrefixHandler(routeScope{}, szPrefix, szMethod, fnHandler, fnOnOverFlow, maxQPS...); err != nil {
+		log.Panicf(context0.NewContext(), "%v", err)
 	}
 }
 
+// Remove retires an exact route at runtime, requests already being served are not affected
+func (h *HttpServer) Remove(szMethod, szPath string) bool {
+	return h.doRemove(routeScope{}, szPath, szMethod, false)
+}
+
+// RemovePrefix retires a prefix route at runtime, e.g. registered by HandlePrefix or Static
+func (h *HttpServer) RemovePrefix(szMethod, szPrefix string) bool {
+	return h.doRemove(routeScope{}, szPrefix, szMethod, true)
+}
+
+// Run serves the default listener on the first free port from 6666 and the listeners added by Listen,
+// it returns nil after Shutdown
 func (h *HttpServer) Run() error {
+	if err := h.listenDefault(); err != nil {
+		return err
+	}
+
+	for _, conf := range h.listeners {
+		listenerTemp, err := net.Listen("tcp", conf.address)
+		if err != nil {
+			h.closeListeners()
+			return errors.Wrapf(err, "http server:%s listen %s at %s fail", h.name, conf.name, conf.address)
+		}
+
+		conf.listener = listenerTemp
+		log.Infof(context.TODO(), "http server:%v listener:%s listen at:%s", h.name, conf.name, listenerTemp.Addr())
+	}
+
+	registry := h.registry
+	if registry == nil {
+		registry = discovery.Default()
+	}
+
+	heartbeat, err := discovery.StartHeartbeat(context0.NewContext(), registry,
+		discovery.Instance{Service: h.name, Address: discovery.AdvertiseAddress(h.listener.Addr())}, discovery.DEFAULT_TTL)
+	if err != nil {
+		h.closeListeners()
+		return errors.Wrapf(err, "http server:%s register fail", h.name)
+	}
+
+	h.lifecycleLock.Lock()
+	h.heartbeat = heartbeat
+	h.lifecycleLock.Unlock()
+
+	group := &utils.Group{}
+	h.serve(group, DEFAULT_LISTENER, h.listener)
+	for _, conf := range h.listeners {
+		h.serve(group, conf.name, conf.listener)
+	}
+
+	err = group.Wait()
+	if err != nil {
+		// a listener is dead, clients must not be routed here any more
+		h.stopHeartbeat(context.TODO())
+	}
+
+	return err
+}
+
+func (h *HttpServer) listenDefault() error {
 	startPort, tryCount := 6666, 1000
 
 	for i := 0; i < tryCount; i++ {
@@ -154,22 +253,84 @@ func (h *HttpServer) Run() error {
 		h.port = port
 		h.listener = listenerTemp
 		log.Infof(context.TODO(), "http server:%v listen at:%d", h.name, port)
+		return nil
+	}
 
-		mux := http.NewServeMux()
-		mux.HandleFunc("/", h.onReq)
+	return errors.Errorf("http server:%s fail too much", h.name)
+}
 
-		//TODO: add service discover logic
+func (h *HttpServer) serve(group *utils.Group, name string, listener net.Listener) {
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request) {
+			h.onReq(rsp, req, name)
+		}),
+	}
 
-		err = http.Serve(h.listener, mux)
-		if err != nil {
-			log.Warningf(context.TODO(), "start http server:%s fail!:%v", h.name, err)
-			continue
+	h.lifecycleLock.Lock()
+	h.servers = append(h.servers, server)
+	h.lifecycleLock.Unlock()
+
+	group.Go(func() error {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Warningf(context.TODO(), "http server:%s listener:%s serve fail!:%v", h.name, name, err)
+			return err
 		}
 
 		return nil
+	})
+}
+
+func (h *HttpServer) closeListeners() {
+	if h.listener != nil {
+		_ = h.listener.Close()
 	}
 
-	return errors.Errorf("http server:%s fail too much", h.name)
+	for _, conf := range h.listeners {
+		if conf.listener != nil {
+			_ = conf.listener.Close()
+		}
+	}
+}
+
+// Shutdown stops accepting requests on every listener and waits for the requests in flight until ctx is done
+func (h *HttpServer) Shutdown(ctx context.Context) error {
+	h.lifecycleLock.Lock()
+	servers := h.servers
+	h.lifecycleLock.Unlock()
+
+	// deregister first so that no new requests are routed here while draining
+	result := h.stopHeartbeat(ctx)
+
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil && result == nil {
+			result = err
+		}
+	}
+
+	log.Infof(ctx, "http server:%s shutdown err:%v", h.name, result)
+	return result
+}
+
+func (h *HttpServer) stopHeartbeat(ctx context.Context) error {
+	h.lifecycleLock.Lock()
+	heartbeat := h.heartbeat
+	h.heartbeat = nil
+	h.lifecycleLock.Unlock()
+
+	if heartbeat == nil {
+		return nil
+	}
+
+	return heartbeat.Stop(ctx)
+}
+
+// SetRegistry sets where Run registers the default listener, discovery.Default() if not set
+func (h *HttpServer) SetRegistry(registry discovery.Registry) {
+	h.registry = registry
+}
+
+func (h *HttpServer) Port() int {
+	return h.port
 }
 
 func (h *HttpServer) OnBeforeRequest(handler func(context.Context, *Response, *Request) bool) {
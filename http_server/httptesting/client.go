@@ -0,0 +1,186 @@
+// Package httptesting drives a HttpServer in-memory for tests, requests go through the
+// same hooks, overflow check, middlewares and router as a running server
+package httptesting
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/RealJonathanYip/framework/context0"
+	"github.com/RealJonathanYip/framework/http_server"
+	"github.com/RealJonathanYip/framework/log"
+	"github.com/google/uuid"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+type Client struct {
+	handler http.Handler
+	// Header is sent with every request
+	Header http.Header
+}
+
+type Result struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	TraceID    string
+	// Logs are the messages logged with TraceID while the request was served
+	Logs []string
+}
+
+func NewClient(handler http.Handler) *Client {
+	watcherOnce.Do(func() {
+		log.AddWatcher(_watcher)
+	})
+
+	return &Client{handler: handler, Header: make(http.Header)}
+}
+
+func (c *Client) NewRequest(method, path string, body io.Reader) *http.Request {
+	req := httptest.NewRequest(method, path, body)
+	for key, values := range c.Header {
+		req.Header[key] = append([]string(nil), values...)
+	}
+
+	return req
+}
+
+// Do serves req and collects the reply, a trace ID is assigned unless req already carries a valid one
+func (c *Client) Do(req *http.Request) *Result {
+	traceID := req.Header.Get(context0.HttpHeaderTraceID)
+	if !context0.ValidTraceID(traceID) {
+		traceID = uuid.New().String()
+		req.Header.Set(context0.HttpHeaderTraceID, traceID)
+	}
+
+	_watcher.watch(traceID)
+	recorder := httptest.NewRecorder()
+	c.handler.ServeHTTP(recorder, req)
+	logs := _watcher.unwatch(traceID)
+
+	return &Result{
+		StatusCode: recorder.Code,
+		Header:     recorder.Header(),
+		Body:       recorder.Body.Bytes(),
+		TraceID:    traceID,
+		Logs:       logs,
+	}
+}
+
+func (c *Client) Get(path string) *Result {
+	return c.Do(c.NewRequest(http.MethodGet, path, nil))
+}
+
+func (c *Client) Delete(path string) *Result {
+	return c.Do(c.NewRequest(http.MethodDelete, path, nil))
+}
+
+func (c *Client) PostJson(path string, body interface{}) *Result {
+	return c.doJson(http.MethodPost, path, body)
+}
+
+func (c *Client) PutJson(path string, body interface{}) *Result {
+	return c.doJson(http.MethodPut, path, body)
+}
+
+func (c *Client) PostForm(path string, form url.Values) *Result {
+	req := c.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return c.Do(req)
+}
+
+func (c *Client) doJson(method, path string, body interface{}) *Result {
+	data, ok := body.([]byte)
+	if !ok {
+		dataTemp, err := json.Marshal(body)
+		if err != nil {
+			panic(err)
+		}
+		data = dataTemp
+	}
+
+	req := c.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+
+	return c.Do(req)
+}
+
+// Reply decodes the body as a http_server.Reply envelope, data receives the Data field when it is not nil
+func (r *Result) Reply(data interface{}) (*http_server.Reply, error) {
+	reply := &http_server.Reply{}
+	if data != nil {
+		reply.Data = data
+	}
+
+	if err := json.Unmarshal(r.Body, reply); err != nil {
+		return nil, err
+	}
+
+	return reply, nil
+}
+
+func (r *Result) DecodeJson(v interface{}) error {
+	return json.Unmarshal(r.Body, v)
+}
+
+// HasLog reports whether any message logged for the request contains text
+func (r *Result) HasLog(text string) bool {
+	for _, line := range r.Logs {
+		if strings.Contains(line, text) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// logWatcher keeps the messages of the trace IDs currently served by clients
+type logWatcher struct {
+	lock   sync.Mutex
+	traces map[string][]string
+}
+
+var (
+	_watcher    = &logWatcher{traces: make(map[string][]string)}
+	watcherOnce sync.Once
+)
+
+func (w *logWatcher) watch(traceID string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.traces[traceID] = make([]string, 0)
+}
+
+func (w *logWatcher) unwatch(traceID string) []string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	logs := w.traces[traceID]
+	delete(w.traces, traceID)
+	return logs
+}
+
+func (w *logWatcher) OnMessage(logLevel, msg string) {
+	index := strings.LastIndex(msg, " traceID:")
+	if index < 0 {
+		return
+	}
+
+	traceID := msg[index+len(" traceID:"):]
+	if end := strings.Index(traceID, " "); end >= 0 {
+		traceID = traceID[:end]
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if logs, exist := w.traces[traceID]; exist {
+		w.traces[traceID] = append(logs, logLevel+": "+msg)
+	}
+}
@@ -0,0 +1,94 @@
+package httptesting
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/RealJonathanYip/framework/context0"
+	"github.com/RealJonathanYip/framework/http_server"
+	"github.com/RealJonathanYip/framework/log"
+	"net/http"
+	"testing"
+)
+
+type user struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+func newServer() *http_server.HttpServer {
+	server := http_server.New("test")
+	server.Post("/users", func(ctx context.Context, resp *http_server.Response, req *http_server.Request) {
+		created := &user{}
+		if err := json.NewDecoder(req.Body).Decode(created); err != nil || created.Name == "" {
+			_ = resp.ReplyJsonWithStatus(ctx, http.StatusBadRequest, &http_server.Reply{Result: 1, Msg: "name required"})
+			return
+		}
+
+		log.Warningf(ctx, "create user:%s", created.Name)
+		resp.Header().Set("X-Role", req.Header.Get("X-Role"))
+		_ = resp.ReplyJsonWithStatus(ctx, http.StatusCreated, &http_server.Reply{
+			Msg:  "created",
+			Data: &user{Name: created.Name, Role: req.Header.Get("X-Role")},
+		})
+	}, nil)
+
+	return server
+}
+
+func TestReplyEnvelope(t *testing.T) {
+	client := NewClient(newServer())
+	client.Header.Set("X-Role", "admin")
+
+	result := client.PostJson("/users", map[string]string{"name": "alice"})
+	if result.StatusCode != http.StatusCreated {
+		t.Fatalf("got status %d body %s", result.StatusCode, result.Body)
+	}
+	if got := result.Header.Get("X-Role"); got != "admin" {
+		t.Fatalf("got X-Role %q, the client header was not sent", got)
+	}
+
+	created := &user{}
+	reply, err := result.Reply(created)
+	if err != nil {
+		t.Fatalf("decode err:%v body %s", err, result.Body)
+	}
+	if reply.Result != 0 || reply.Msg != "created" || created.Name != "alice" || created.Role != "admin" {
+		t.Fatalf("got reply %+v data %+v", reply, created)
+	}
+
+	result = client.PostJson("/users", map[string]string{})
+	if reply, err := result.Reply(nil); err != nil || result.StatusCode != http.StatusBadRequest || reply.Result != 1 {
+		t.Fatalf("got status %d reply %+v err:%v", result.StatusCode, reply, err)
+	}
+}
+
+func TestTraceID(t *testing.T) {
+	client := NewClient(newServer())
+
+	result := client.PostJson("/users", map[string]string{"name": "bob"})
+	if result.TraceID == "" || result.Header.Get(context0.HttpHeaderTraceID) != result.TraceID {
+		t.Fatalf("got trace ID %q, reply header %q", result.TraceID, result.Header.Get(context0.HttpHeaderTraceID))
+	}
+	if !result.HasLog("create user:bob") {
+		t.Fatalf("handler log not collected, got %v", result.Logs)
+	}
+
+	req := client.NewRequest(http.MethodGet, "/missing", nil)
+	req.Header.Set(context0.HttpHeaderTraceID, "trace-1")
+	result = client.Do(req)
+	if result.StatusCode != http.StatusNotFound || result.TraceID != "trace-1" ||
+		result.Header.Get(context0.HttpHeaderTraceID) != "trace-1" {
+		t.Fatalf("got status %d trace ID %q", result.StatusCode, result.Header.Get(context0.HttpHeaderTraceID))
+	}
+	if result.HasLog("create user:bob") {
+		t.Fatal("logs of another request collected")
+	}
+
+	// the server would not continue an invalid trace ID, the client assigns a valid one
+	req = client.NewRequest(http.MethodGet, "/missing", nil)
+	req.Header.Set(context0.HttpHeaderTraceID, "trace 1")
+	result = client.Do(req)
+	if result.TraceID == "trace 1" || result.Header.Get(context0.HttpHeaderTraceID) != result.TraceID {
+		t.Fatalf("got trace ID %q, reply header %q", result.TraceID, result.Header.Get(context0.HttpHeaderTraceID))
+	}
+}
@@ -0,0 +1,228 @@
+package http_server
+
+import (
+	"context"
+	"encoding/xml"
+	"github.com/RealJonathanYip/framework/config"
+	"github.com/RealJonathanYip/framework/log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// catalog file, one file per language:
+//
+//	<messages lang="zh-CN">
+//		<message code="1001">用户不存在</message>
+//	</messages>
+type messageFile struct {
+	XMLName  xml.Name      `xml:"messages"`
+	Lang     string        `xml:"lang,attr"`
+	Messages []messageItem `xml:"message"`
+}
+
+type messageItem struct {
+	Code  HttpResult `xml:"code,attr"`
+	Value string     `xml:",chardata"`
+}
+
+// MessageCatalog keeps the Reply messages of every HttpResult code by language
+type MessageCatalog struct {
+	lock     sync.RWMutex
+	messages map[string]map[HttpResult]string
+	fallback string
+}
+
+// NewMessageCatalog creates a catalog using fallback when no accepted language has the code
+func NewMessageCatalog(fallback string) *MessageCatalog {
+	return &MessageCatalog{
+		messages: make(map[string]map[HttpResult]string),
+		fallback: normalizeLang(fallback),
+	}
+}
+
+func (c *MessageCatalog) Set(lang string, code HttpResult, msg string) {
+	lang = normalizeLang(lang)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, exist := c.messages[lang]; !exist {
+		c.messages[lang] = make(map[HttpResult]string)
+	}
+	c.messages[lang][code] = msg
+}
+
+// LoadFile loads one xml catalog file, messages of the same language and code are replaced
+func (c *MessageCatalog) LoadFile(ctx context.Context, file string) error {
+	content := &messageFile{}
+	if err := config.ReadXml(ctx, file, content); err != nil {
+		return err
+	}
+
+	if content.Lang == "" {
+		log.Warningf(ctx, "message file:%s without lang", file)
+		return os.ErrInvalid
+	}
+
+	for _, item := range content.Messages {
+		c.Set(content.Lang, item.Code, strings.TrimSpace(item.Value))
+	}
+
+	return nil
+}
+
+// LoadDir loads every *.xml file in dir
+func (c *MessageCatalog) LoadDir(ctx context.Context, dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.xml"))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if err := c.LoadFile(ctx, file); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Message looks up code for the languages in preference order, then the fallback language
+func (c *MessageCatalog) Message(code HttpResult, langs ...string) (string, string, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	for _, lang := range langs {
+		lang = normalizeLang(lang)
+		if msg, exist := c.messages[lang][code]; exist {
+			return msg, lang, true
+		}
+
+		// zh-cn -> zh, then en -> en-us
+		base := baseLang(lang)
+		if msg, exist := c.messages[base][code]; exist {
+			return msg, base, true
+		}
+
+		// the same order for every request when several regions share a base language
+		matchLang := ""
+		for catalogLang, messages := range c.messages {
+			if _, exist := messages[code]; exist && baseLang(catalogLang) == base && (matchLang == "" || catalogLang < matchLang) {
+				matchLang = catalogLang
+			}
+		}
+
+		if matchLang != "" {
+			return c.messages[matchLang][code], matchLang, true
+		}
+	}
+
+	if msg, exist := c.messages[c.fallback][code]; exist {
+		return msg, c.fallback, true
+	}
+
+	return "", "", false
+}
+
+func normalizeLang(lang string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(lang), "_", "-"))
+}
+
+func baseLang(lang string) string {
+	if index := strings.Index(lang, "-"); index > 0 {
+		return lang[:index]
+	}
+
+	return lang
+}
+
+// parseAcceptLanguage returns the languages of an Accept-Language header by quality, "*" and q=0 are skipped
+func parseAcceptLanguage(header string) []string {
+	type weighted struct {
+		lang    string
+		quality float64
+	}
+
+	var langs []weighted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		lang := strings.TrimSpace(fields[0])
+		if lang == "" || lang == "*" {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if value, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = value
+				}
+			}
+		}
+
+		if quality > 0 {
+			langs = append(langs, weighted{lang: lang, quality: quality})
+		}
+	}
+
+	sort.SliceStable(langs, func(i, j int) bool {
+		return langs[i].quality > langs[j].quality
+	})
+
+	result := make([]string, 0, len(langs))
+	for _, item := range langs {
+		result = append(result, item.lang)
+	}
+
+	return result
+}
+
+// SetMessageCatalog sets the catalog used by Response.ReplyCode
+func (h *HttpServer) SetMessageCatalog(catalog *MessageCatalog) {
+	h.messageCatalog = catalog
+}
+
+// Languages returns the languages accepted by the client in preference order
+func (r *Request) Languages() []string {
+	return parseAcceptLanguage(r.Header.Get("Accept-Language"))
+}
+
+// Message returns the localized message of code for the request, "" if the server has no catalog or no message
+func (r *Request) Message(code HttpResult) string {
+	msg, _, _ := r.message(code)
+	return msg
+}
+
+func (r *Request) message(code HttpResult) (string, string, bool) {
+	if r.server == nil || r.server.messageCatalog == nil {
+		return "", "", false
+	}
+
+	return r.server.messageCatalog.Message(code, r.Languages()...)
+}
+
+// ReplyCode replies a Reply envelope whose Msg is the message of code in the client's language
+func (r *Response) ReplyCode(ctx context.Context, code HttpResult, data interface{}) error {
+	return r.ReplyCodeWithStatus(ctx, http.StatusOK, code, data)
+}
+
+func (r *Response) ReplyCodeWithStatus(ctx context.Context, status int, code HttpResult, data interface{}) error {
+	reply := &Reply{Result: code, Data: data}
+	if r.request != nil {
+		if msg, lang, exist := r.request.message(code); exist {
+			reply.Msg = msg
+			r.Header().Set("Content-Language", lang)
+			r.Header().Add("Vary", "Accept-Language")
+		} else {
+			log.Warningf(ctx, "no message for result:%d", code)
+		}
+	}
+
+	return r.ReplyJsonWithStatus(ctx, status, reply)
+}
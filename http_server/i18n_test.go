@@ -0,0 +1,74 @@
+package http_server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseAcceptLanguage(t *testing.T) {
+	got := parseAcceptLanguage("fr;q=0.5, zh-CN, *;q=0.1, de;q=0, en;q=0.8")
+	if want := []string{"zh-CN", "en", "fr"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestMessageCatalogLoadDir(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"zh.xml": `<messages lang="zh_CN"><message code="1001"> 用户不存在 </message></messages>`,
+		"en.xml": `<messages lang="en-US"><message code="1001">user not found</message><message code="1002">bad password</message></messages>`,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	catalog := NewMessageCatalog("en-US")
+	if err := catalog.LoadDir(context.TODO(), dir); err != nil {
+		t.Fatalf("LoadDir err:%v", err)
+	}
+
+	if msg, lang, _ := catalog.Message(1001, "zh-CN"); msg != "用户不存在" || lang != "zh-cn" {
+		t.Errorf("exact language got %q %q", msg, lang)
+	}
+	if msg, lang, _ := catalog.Message(1001, "zh-TW"); msg != "用户不存在" || lang != "zh-cn" {
+		t.Errorf("other region got %q %q", msg, lang)
+	}
+	if msg, lang, _ := catalog.Message(1002, "zh"); msg != "bad password" || lang != "en-us" {
+		t.Errorf("fallback got %q %q", msg, lang)
+	}
+	if _, _, exist := catalog.Message(1003, "zh"); exist {
+		t.Error("unknown code found")
+	}
+}
+
+func TestReplyCode(t *testing.T) {
+	catalog := NewMessageCatalog("en")
+	catalog.Set("en", 1001, "user not found")
+	catalog.Set("zh", 1001, "用户不存在")
+
+	server := New("test")
+	server.SetMessageCatalog(catalog)
+	server.Get("/user", func(ctx context.Context, resp *Response, req *Request) {
+		_ = resp.ReplyCodeWithStatus(ctx, http.StatusNotFound, 1001, nil)
+	}, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/user", nil)
+	req.Header.Set("Accept-Language", "zh-CN,zh;q=0.9,en;q=0.8")
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+
+	reply := &Reply{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), reply); err != nil || reply.Result != 1001 || reply.Msg != "用户不存在" {
+		t.Fatalf("got %q err:%v", recorder.Body.String(), err)
+	}
+	if recorder.Code != http.StatusNotFound || recorder.Header().Get("Content-Language") != "zh" || recorder.Header().Get("Vary") != "Accept-Language" {
+		t.Fatalf("got status %d header %v", recorder.Code, recorder.Header())
+	}
+}
@@ -0,0 +1,295 @@
+package http_server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/RealJonathanYip/framework/context0"
+	"github.com/RealJonathanYip/framework/log"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+	"reflect"
+	"sync"
+)
+
+const (
+	JSON_RPC_PARSE_ERROR      = -32700
+	JSON_RPC_INVALID_REQUEST  = -32600
+	JSON_RPC_METHOD_NOT_FOUND = -32601
+	JSON_RPC_INVALID_PARAMS   = -32602
+	JSON_RPC_INTERNAL_ERROR   = -32603
+	JSON_RPC_SERVER_ERROR     = -32000
+)
+
+// JsonRpcError is a JSON-RPC 2.0 error object, methods can return it to choose the code
+type JsonRpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *JsonRpcError) Error() string {
+	return fmt.Sprintf("json rpc error %d: %s", e.Code, e.Message)
+}
+
+type jsonRpcRequest struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type jsonRpcResponse struct {
+	Version string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *JsonRpcError   `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type jsonRpcMethod struct {
+	fn        reflect.Value
+	paramType reflect.Type
+}
+
+var (
+	_contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	_errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// JsonRpc dispatches JSON-RPC 2.0 calls, including batches and notifications, to registered go functions
+//
+// Example: rpc := NewJsonRpc(); rpc.Register("user.get", getUser); server.Post("/jsonrpc", rpc.Serve, nil)
+type JsonRpc struct {
+	methods map[string]*jsonRpcMethod
+	lock    sync.RWMutex
+	maxBody int64
+}
+
+type jsonRpcOption interface {
+	apply(*JsonRpc)
+}
+
+type jsonRpcOptionFunc func(*JsonRpc)
+
+func (f jsonRpcOptionFunc) apply(rpc *JsonRpc) {
+	f(rpc)
+}
+
+// JsonRpcMaxBody rejects request bodies larger than size bytes, default 1MB
+func JsonRpcMaxBody(size int) jsonRpcOption {
+	return jsonRpcOptionFunc(func(rpc *JsonRpc) {
+		rpc.maxBody = int64(size)
+	})
+}
+
+func NewJsonRpc(opts ...jsonRpcOption) *JsonRpc {
+	rpc := &JsonRpc{methods: make(map[string]*jsonRpcMethod), maxBody: 1 << 20}
+	for _, opt := range opts {
+		opt.apply(rpc)
+	}
+
+	return rpc
+}
+
+// Register binds name to fn, fn looks like func(ctx context.Context[, params T]) (R, error),
+// params accept both by-name (object) and by-position (array) forms
+func (j *JsonRpc) Register(name string, fn interface{}) error {
+	fnValue := reflect.ValueOf(fn)
+	fnType := fnValue.Type()
+	if fnType.Kind() != reflect.Func {
+		return errors.Errorf("json rpc method:%s is not a func", name)
+	}
+
+	if fnType.NumIn() < 1 || fnType.NumIn() > 2 || fnType.In(0) != _contextType {
+		return errors.Errorf("json rpc method:%s must accept (context.Context[, params])", name)
+	}
+
+	if fnType.NumOut() != 2 || fnType.Out(1) != _errorType {
+		return errors.Errorf("json rpc method:%s must return (result, error)", name)
+	}
+
+	method := &jsonRpcMethod{fn: fnValue}
+	if fnType.NumIn() == 2 {
+		method.paramType = fnType.In(1)
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if _, exist := j.methods[name]; exist {
+		return errors.Errorf("json rpc method:%s exist!", name)
+	}
+	j.methods[name] = method
+
+	log.Infof(context0.NewContext(), "register json rpc method : %v", name)
+	return nil
+}
+
+func (j *JsonRpc) Serve(ctx context.Context, resp *Response, req *Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(resp, req.Body, j.maxBody))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			log.Warningf(ctx, "json rpc body over %d bytes", j.maxBody)
+			_ = resp.ReplyJsonWithStatus(ctx, http.StatusRequestEntityTooLarge,
+				newJsonRpcError(nil, JSON_RPC_INVALID_REQUEST, "request too large"))
+			return
+		}
+
+		log.Warningf(ctx, "read json rpc body fail:%v", err)
+		_ = resp.ReplyJson(ctx, newJsonRpcError(nil, JSON_RPC_PARSE_ERROR, "read body fail"))
+		return
+	}
+
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '[' {
+		if result := j.call(ctx, data); result != nil {
+			_ = resp.ReplyJson(ctx, result)
+			return
+		}
+
+		resp.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var batch []json.RawMessage
+	if err := json.Unmarshal(data, &batch); err != nil {
+		_ = resp.ReplyJson(ctx, newJsonRpcError(nil, JSON_RPC_PARSE_ERROR, "parse error"))
+		return
+	}
+
+	if len(batch) == 0 {
+		_ = resp.ReplyJson(ctx, newJsonRpcError(nil, JSON_RPC_INVALID_REQUEST, "invalid request"))
+		return
+	}
+
+	results := make([]*jsonRpcResponse, 0, len(batch))
+	for _, item := range batch {
+		if result := j.call(ctx, item); result != nil {
+			results = append(results, result)
+		}
+	}
+
+	if len(results) == 0 {
+		resp.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	_ = resp.ReplyJson(ctx, results)
+}
+
+// call runs one request, nil is returned for notifications
+func (j *JsonRpc) call(ctx context.Context, data []byte) (result *jsonRpcResponse) {
+	var request jsonRpcRequest
+	if err := json.Unmarshal(data, &request); err != nil {
+		var probe interface{}
+		if json.Unmarshal(data, &probe) != nil {
+			return newJsonRpcError(nil, JSON_RPC_PARSE_ERROR, "parse error")
+		}
+		return newJsonRpcError(nil, JSON_RPC_INVALID_REQUEST, "invalid request")
+	}
+
+	notification := request.ID == nil
+	if request.Version != "2.0" || request.Method == "" {
+		return newJsonRpcError(request.ID, JSON_RPC_INVALID_REQUEST, "invalid request")
+	}
+
+	j.lock.RLock()
+	method, exist := j.methods[request.Method]
+	j.lock.RUnlock()
+	if !exist {
+		if notification {
+			return nil
+		}
+		return newJsonRpcError(request.ID, JSON_RPC_METHOD_NOT_FOUND, "method not found")
+	}
+
+	args := []reflect.Value{reflect.ValueOf(ctx)}
+	if method.paramType != nil {
+		param, err := method.decodeParams(request.Params)
+		if err != nil {
+			if notification {
+				return nil
+			}
+			return newJsonRpcError(request.ID, JSON_RPC_INVALID_PARAMS, err.Error())
+		}
+		args = append(args, param)
+	}
+
+	defer func() {
+		if err := recover(); err != nil {
+			log.Errorf(ctx, "json rpc method:%s panic:%v", request.Method, err)
+			result = newJsonRpcError(request.ID, JSON_RPC_INTERNAL_ERROR, "internal error")
+			if notification {
+				result = nil
+			}
+		}
+	}()
+
+	outs := method.fn.Call(args)
+	if notification {
+		return nil
+	}
+
+	if errValue := outs[1].Interface(); errValue != nil {
+		var rpcError *JsonRpcError
+		if errors.As(errValue.(error), &rpcError) {
+			return &jsonRpcResponse{Version: "2.0", Error: rpcError, ID: request.ID}
+		}
+
+		// other errors may carry internal details, they are only logged
+		log.Warningf(ctx, "json rpc method:%s fail:%v", request.Method, errValue)
+		return newJsonRpcError(request.ID, JSON_RPC_SERVER_ERROR, "server error")
+	}
+
+	data, err := json.Marshal(outs[0].Interface())
+	if err != nil {
+		log.Warningf(ctx, "json rpc method:%s marshal result fail:%v", request.Method, err)
+		return newJsonRpcError(request.ID, JSON_RPC_INTERNAL_ERROR, "internal error")
+	}
+
+	return &jsonRpcResponse{Version: "2.0", Result: data, ID: request.ID}
+}
+
+func (m *jsonRpcMethod) decodeParams(params json.RawMessage) (reflect.Value, error) {
+	isPointer := m.paramType.Kind() == reflect.Ptr
+	elemType := m.paramType
+	if isPointer {
+		elemType = m.paramType.Elem()
+	}
+
+	param := reflect.New(elemType)
+	params = bytes.TrimSpace(params)
+	if len(params) > 0 {
+		// a single by-position param is unwrapped unless the param itself is a list
+		if params[0] == '[' && elemType.Kind() != reflect.Slice && elemType.Kind() != reflect.Array {
+			var positions []json.RawMessage
+			if err := json.Unmarshal(params, &positions); err != nil {
+				return reflect.Value{}, err
+			}
+			if len(positions) != 1 {
+				return reflect.Value{}, errors.Errorf("expect 1 positional param, got %d", len(positions))
+			}
+			params = positions[0]
+		}
+
+		if err := json.Unmarshal(params, param.Interface()); err != nil {
+			return reflect.Value{}, err
+		}
+	}
+
+	if isPointer {
+		return param, nil
+	}
+
+	return param.Elem(), nil
+}
+
+func newJsonRpcError(id json.RawMessage, code int, message string) *jsonRpcResponse {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+
+	return &jsonRpcResponse{Version: "2.0", Error: &JsonRpcError{Code: code, Message: message}, ID: id}
+}
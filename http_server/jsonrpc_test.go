@@ -0,0 +1,181 @@
+package http_server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type addParams struct {
+	A int `json:"a"`
+	B int `json:"b"`
+}
+
+func newJsonRpcServer(t *testing.T) (*HttpServer, *int) {
+	notified := new(int)
+	rpc := NewJsonRpc()
+	for name, fn := range map[string]interface{}{
+		"add": func(ctx context.Context, params *addParams) (int, error) {
+			return params.A + params.B, nil
+		},
+		"sum": func(ctx context.Context, numbers []int) (int, error) {
+			total := 0
+			for _, number := range numbers {
+				total += number
+			}
+			return total, nil
+		},
+		"notify": func(ctx context.Context) (interface{}, error) {
+			*notified++
+			return nil, nil
+		},
+		"forbidden": func(ctx context.Context) (interface{}, error) {
+			return nil, &JsonRpcError{Code: 403, Message: "forbidden", Data: "admin only"}
+		},
+		"panic": func(ctx context.Context) (interface{}, error) {
+			panic("boom")
+		},
+		"fail": func(ctx context.Context) (interface{}, error) {
+			return nil, errors.New("dial db 10.0.0.3:3306 fail")
+		},
+	} {
+		if err := rpc.Register(name, fn); err != nil {
+			t.Fatalf("register %s err:%v", name, err)
+		}
+	}
+
+	server := New("test")
+	server.Post("/jsonrpc", rpc.Serve, nil)
+	return server, notified
+}
+
+func callJsonRpc(server *HttpServer, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/jsonrpc", strings.NewReader(body)))
+	return recorder
+}
+
+func decodeJsonRpc(t *testing.T, recorder *httptest.ResponseRecorder) *jsonRpcResponse {
+	result := &jsonRpcResponse{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), result); err != nil {
+		t.Fatalf("decode %q err:%v", recorder.Body.String(), err)
+	}
+
+	return result
+}
+
+func TestJsonRpcCall(t *testing.T) {
+	server, _ := newJsonRpcServer(t)
+
+	result := decodeJsonRpc(t, callJsonRpc(server, `{"jsonrpc":"2.0","method":"add","params":{"a":1,"b":2},"id":1}`))
+	if string(result.Result) != "3" || string(result.ID) != "1" || result.Error != nil {
+		t.Fatalf("by-name got %+v", result)
+	}
+
+	result = decodeJsonRpc(t, callJsonRpc(server, `{"jsonrpc":"2.0","method":"add","params":[{"a":2,"b":2}],"id":"x"}`))
+	if string(result.Result) != "4" || string(result.ID) != `"x"` {
+		t.Fatalf("by-position got %+v", result)
+	}
+
+	result = decodeJsonRpc(t, callJsonRpc(server, `{"jsonrpc":"2.0","method":"sum","params":[1,2,3],"id":2}`))
+	if string(result.Result) != "6" {
+		t.Fatalf("list param got %+v", result)
+	}
+}
+
+func TestJsonRpcErrors(t *testing.T) {
+	server, _ := newJsonRpcServer(t)
+
+	for body, want := range map[string]int{
+		`{"jsonrpc":"2.0","method":"add","params":{"a":1`:        JSON_RPC_PARSE_ERROR,
+		`{"jsonrpc":"1.0","method":"add","id":1}`:                JSON_RPC_INVALID_REQUEST,
+		`{"jsonrpc":"2.0","method":"missing","id":1}`:            JSON_RPC_METHOD_NOT_FOUND,
+		`{"jsonrpc":"2.0","method":"add","params":"x","id":1}`:   JSON_RPC_INVALID_PARAMS,
+		`{"jsonrpc":"2.0","method":"add","params":[1,2],"id":1}`: JSON_RPC_INVALID_PARAMS,
+		`{"jsonrpc":"2.0","method":"panic","id":1}`:              JSON_RPC_INTERNAL_ERROR,
+	} {
+		result := decodeJsonRpc(t, callJsonRpc(server, body))
+		if result.Error == nil || result.Error.Code != want {
+			t.Errorf("%s got %+v, want code %d", body, result.Error, want)
+		}
+	}
+
+	if result := decodeJsonRpc(t, callJsonRpc(server, `[]`)); result.Error == nil || result.Error.Code != JSON_RPC_INVALID_REQUEST {
+		t.Errorf("empty batch got %+v", result.Error)
+	}
+
+	result := decodeJsonRpc(t, callJsonRpc(server, `{"jsonrpc":"2.0","method":"forbidden","id":1}`))
+	if result.Error == nil || result.Error.Code != 403 || result.Error.Message != "forbidden" || result.Error.Data != "admin only" {
+		t.Fatalf("JsonRpcError got %+v", result.Error)
+	}
+}
+
+func TestJsonRpcNotification(t *testing.T) {
+	server, notified := newJsonRpcServer(t)
+
+	recorder := callJsonRpc(server, `{"jsonrpc":"2.0","method":"notify"}`)
+	if recorder.Code != http.StatusNoContent || recorder.Body.Len() != 0 || *notified != 1 {
+		t.Fatalf("got status %d body %q after %d notifications", recorder.Code, recorder.Body.String(), *notified)
+	}
+
+	if recorder = callJsonRpc(server, `{"jsonrpc":"2.0","method":"missing"}`); recorder.Code != http.StatusNoContent {
+		t.Fatalf("unknown notification got status %d", recorder.Code)
+	}
+}
+
+func TestJsonRpcBatch(t *testing.T) {
+	server, notified := newJsonRpcServer(t)
+
+	recorder := callJsonRpc(server, `[
+		{"jsonrpc":"2.0","method":"add","params":{"a":1,"b":1},"id":1},
+		{"jsonrpc":"2.0","method":"notify"},
+		{"jsonrpc":"2.0","method":"missing","id":2},
+		1
+	]`)
+
+	var results []*jsonRpcResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &results); err != nil || len(results) != 3 {
+		t.Fatalf("got %q err:%v", recorder.Body.String(), err)
+	}
+	if string(results[0].Result) != "2" || results[1].Error.Code != JSON_RPC_METHOD_NOT_FOUND ||
+		results[2].Error.Code != JSON_RPC_INVALID_REQUEST || *notified != 1 {
+		t.Fatalf("got %q after %d notifications", recorder.Body.String(), *notified)
+	}
+
+	if recorder = callJsonRpc(server, `[{"jsonrpc":"2.0","method":"notify"}]`); recorder.Code != http.StatusNoContent {
+		t.Fatalf("batch of notifications got status %d", recorder.Code)
+	}
+}
+
+func TestJsonRpcServerErrorHidden(t *testing.T) {
+	server, _ := newJsonRpcServer(t)
+
+	result := decodeJsonRpc(t, callJsonRpc(server, `{"jsonrpc":"2.0","method":"fail","id":1}`))
+	if result.Error == nil || result.Error.Code != JSON_RPC_SERVER_ERROR || result.Error.Message != "server error" {
+		t.Fatalf("got %+v, want a generic server error", result.Error)
+	}
+}
+
+func TestJsonRpcMaxBody(t *testing.T) {
+	rpc := NewJsonRpc(JsonRpcMaxBody(64))
+	if err := rpc.Register("echo", func(ctx context.Context, text string) (string, error) { return text, nil }); err != nil {
+		t.Fatalf("register err:%v", err)
+	}
+	server := New("test")
+	server.Post("/jsonrpc", rpc.Serve, nil)
+
+	result := decodeJsonRpc(t, callJsonRpc(server, `{"jsonrpc":"2.0","method":"echo","params":["hi"],"id":1}`))
+	if string(result.Result) != `"hi"` {
+		t.Fatalf("body under the limit got %+v", result)
+	}
+
+	recorder := callJsonRpc(server, `{"jsonrpc":"2.0","method":"echo","params":["`+strings.Repeat("a", 64)+`"],"id":1}`)
+	if result = decodeJsonRpc(t, recorder); recorder.Code != http.StatusRequestEntityTooLarge ||
+		result.Error == nil || result.Error.Code != JSON_RPC_INVALID_REQUEST {
+		t.Fatalf("body over the limit got status %d error %+v", recorder.Code, result.Error)
+	}
+}
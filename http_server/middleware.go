@@ -0,0 +1,98 @@
+package http_server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+)
+
+// Middleware wraps a route handler, it is applied after the overflow check and OnBeforeRequest hooks
+type Middleware func(func(context.Context, *Response, *Request)) func(context.Context, *Response, *Request)
+
+// Use appends middlewares applied to every route, the first one is the outermost
+func (h *HttpServer) Use(middlewares ...Middleware) {
+	h.middlewares = append(h.middlewares, middlewares...)
+}
+
+// Chain wraps a single route handler with middlewares, the first one is the outermost
+func Chain(handler func(context.Context, *Response, *Request), middlewares ...Middleware) func(context.Context, *Response, *Request) {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+
+	return handler
+}
+
+// WrapHandler serves a route by a standard http.Handler, the OnBeforeReply hooks run before it
+//
+// Example: server.Get("/ready", WrapHandler(rpcServer.Readiness()), nil)
+func WrapHandler(handler http.Handler) func(context.Context, *Response, *Request) {
+	return func(ctx context.Context, resp *Response, req *Request) {
+		resp.BeforeReply(ctx)
+		handler.ServeHTTP(resp, req.Request)
+	}
+}
+
+// responseRecorder captures status and body written by a handler,
+// when passThrough is set the data also reaches the underlying writer
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	body        bytes.Buffer
+	maxBody     int
+	truncated   bool
+	passThrough bool
+	wroteHeader bool
+}
+
+func newResponseRecorder(rsp http.ResponseWriter, maxBody int, passThrough bool) *responseRecorder {
+	return &responseRecorder{
+		ResponseWriter: rsp,
+		status:         http.StatusOK,
+		maxBody:        maxBody,
+		passThrough:    passThrough,
+	}
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.wroteHeader {
+		return
+	}
+
+	r.wroteHeader = true
+	r.status = status
+	if r.passThrough {
+		r.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+
+	if r.maxBody < 0 || r.body.Len()+len(data) <= r.maxBody {
+		r.body.Write(data)
+	} else {
+		if remain := r.maxBody - r.body.Len(); remain > 0 {
+			r.body.Write(data[:remain])
+		}
+		r.truncated = true
+	}
+
+	if r.passThrough {
+		return r.ResponseWriter.Write(data)
+	}
+
+	return len(data), nil
+}
+
+func (r *responseRecorder) Flush() {
+	if !r.passThrough {
+		return
+	}
+
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,25 @@
+package http_server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWrapHandler(t *testing.T) {
+	server := New("test")
+	server.OnBeforeReply(func(ctx context.Context, resp *Response, req *Request) {
+		resp.Header().Set("X-Before-Reply", "1")
+	})
+	server.Get("/plain", WrapHandler(http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request) {
+		rsp.WriteHeader(http.StatusAccepted)
+		_, _ = rsp.Write([]byte(req.URL.Path))
+	})), nil)
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/plain", nil))
+	if recorder.Code != http.StatusAccepted || recorder.Body.String() != "/plain" || recorder.Header().Get("X-Before-Reply") != "1" {
+		t.Fatalf("got status %d body %q header %v", recorder.Code, recorder.Body.String(), recorder.Header())
+	}
+}
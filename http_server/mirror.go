@@ -0,0 +1,295 @@
+package http_server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/RealJonathanYip/framework/context0"
+	"github.com/RealJonathanYip/framework/http_client"
+	"github.com/RealJonathanYip/framework/log"
+	"io"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const HttpHeaderMirror = "X-Mirror"
+
+// mirrorDropHeaders carry credentials of the user, the shadow may be run by another team
+var mirrorDropHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+}
+
+type mirrorConf struct {
+	sampleRate    float64
+	maxBody       int
+	maxConcurrent int
+	timeout       time.Duration
+	client        *http_client.Client
+	compare       bool
+	methods       map[string]bool
+	bodyLog       *bodyLogConf
+}
+
+type mirrorOption interface {
+	apply(*mirrorConf)
+}
+
+type mirrorOptionFunc func(*mirrorConf)
+
+func (f mirrorOptionFunc) apply(conf *mirrorConf) {
+	f(conf)
+}
+
+// MirrorSampleRate mirrors percent (0-100) of the requests, default 100
+func MirrorSampleRate(percent float64) mirrorOption {
+	return mirrorOptionFunc(func(conf *mirrorConf) {
+		conf.sampleRate = percent
+	})
+}
+
+// MirrorMaxBody skips requests whose body is larger and caps the response bytes compared, default 64KB
+func MirrorMaxBody(size int) mirrorOption {
+	return mirrorOptionFunc(func(conf *mirrorConf) {
+		conf.maxBody = size
+	})
+}
+
+// MirrorMaxConcurrent drops mirrored requests while this many are in flight, default 64
+func MirrorMaxConcurrent(n int) mirrorOption {
+	return mirrorOptionFunc(func(conf *mirrorConf) {
+		conf.maxConcurrent = n
+	})
+}
+
+// MirrorTimeout bounds each shadow request, default 5s
+func MirrorTimeout(timeout time.Duration) mirrorOption {
+	return mirrorOptionFunc(func(conf *mirrorConf) {
+		conf.timeout = timeout
+	})
+}
+
+// MirrorClient sends shadow requests with client instead of a traced client without retry
+func MirrorClient(client *http_client.Client) mirrorOption {
+	return mirrorOptionFunc(func(conf *mirrorConf) {
+		conf.client = client
+	})
+}
+
+// MirrorMethods mirrors requests of these methods only, default GET and HEAD,
+// add the others only when the shadow cannot change shared data
+func MirrorMethods(methods ...string) mirrorOption {
+	return mirrorOptionFunc(func(conf *mirrorConf) {
+		conf.methods = make(map[string]bool, len(methods))
+		for _, method := range methods {
+			conf.methods[strings.ToUpper(method)] = true
+		}
+	})
+}
+
+// MirrorLogMaxBody caps the bytes of each body logged in a diff, default 512
+func MirrorLogMaxBody(size int) mirrorOption {
+	return mirrorOptionFunc(func(conf *mirrorConf) {
+		conf.bodyLog.maxSize = size
+	})
+}
+
+// MirrorRedactFields masks json fields with these names at any depth in the bodies logged, case insensitive
+func MirrorRedactFields(fields ...string) mirrorOption {
+	return mirrorOptionFunc(func(conf *mirrorConf) {
+		for _, field := range fields {
+			conf.bodyLog.redactFields[strings.ToLower(field)] = true
+		}
+	})
+}
+
+// MirrorCompare turns the primary/shadow diff log on or off, default on
+func MirrorCompare(compare bool) mirrorOption {
+	return mirrorOptionFunc(func(conf *mirrorConf) {
+		conf.compare = compare
+	})
+}
+
+type mirrorRequest struct {
+	method    string
+	uri       string
+	header    http.Header
+	body      []byte
+	status    int
+	respBody  []byte
+	truncated bool
+}
+
+// Mirror copies sampled requests to shadowURL after the primary handler replied, shadow responses are
+// discarded and only compared with the primary ones in the log, so primary latency is not affected.
+// Only GET and HEAD are mirrored by default and Authorization and Cookie are never sent to the shadow
+//
+// Example: server.Use(Mirror("http://10.0.0.2:6666", MirrorSampleRate(5)))
+func Mirror(shadowURL string, opts ...mirrorOption) Middleware {
+	conf := &mirrorConf{
+		sampleRate:    100,
+		maxBody:       64 << 10,
+		maxConcurrent: 64,
+		timeout:       5 * time.Second,
+		compare:       true,
+		methods:       map[string]bool{_METHOD_GET: true, _METHOD_HEAD: true},
+		bodyLog:       &bodyLogConf{maxSize: 512, redactFields: make(map[string]bool)},
+	}
+	for _, opt := range opts {
+		opt.apply(conf)
+	}
+	conf.bodyLog.compile()
+
+	if conf.client == nil {
+		conf.client = http_client.New(http_client.Timeout(conf.timeout))
+	}
+
+	shadowURL = strings.TrimRight(shadowURL, "/")
+	slots := make(chan struct{}, conf.maxConcurrent)
+
+	return func(handler func(context.Context, *Response, *Request)) func(context.Context, *Response, *Request) {
+		return func(ctx context.Context, resp *Response, req *Request) {
+			// never mirror a mirrored request again
+			if req.Header.Get(HttpHeaderMirror) != "" || !conf.methods[req.Method] || !conf.sampled() {
+				handler(ctx, resp, req)
+				return
+			}
+
+			body, ok := conf.captureRequest(req)
+			if !ok {
+				handler(ctx, resp, req)
+				return
+			}
+
+			mirror := &mirrorRequest{method: req.Method, uri: req.URL.RequestURI(), header: req.Header.Clone(), body: body}
+
+			writer := resp.ResponseWriter
+			recorder := newResponseRecorder(writer, conf.maxBody, true)
+			resp.ResponseWriter = recorder
+			handler(ctx, resp, req)
+			resp.ResponseWriter = writer
+
+			mirror.status, mirror.respBody, mirror.truncated = recorder.status, recorder.body.Bytes(), recorder.truncated
+
+			select {
+			case slots <- struct{}{}:
+			default:
+				log.Warningf(ctx, "【mirror】drop %s %s, %d in flight", mirror.method, mirror.uri, conf.maxConcurrent)
+				return
+			}
+
+			go func(ctx context.Context) {
+				defer func() { <-slots }()
+				conf.send(ctx, shadowURL, mirror)
+			}(context0.Copy(ctx))
+		}
+	}
+}
+
+func (c *mirrorConf) sampled() bool {
+	return c.sampleRate >= 100 || rand.Float64()*100 < c.sampleRate
+}
+
+// captureRequest reads the whole body for the shadow request and puts it back, false when it exceeds maxBody
+func (c *mirrorConf) captureRequest(req *Request) ([]byte, bool) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, true
+	}
+
+	buffer := make([]byte, c.maxBody+1)
+	n, err := io.ReadFull(req.Body, buffer)
+	buffer = buffer[:n]
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		req.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(buffer), errReader{err}), Closer: req.Body}
+		return nil, false
+	}
+
+	req.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(buffer), req.Body), Closer: req.Body}
+	return buffer, n <= c.maxBody
+}
+
+func (c *mirrorConf) send(ctx context.Context, shadowURL string, mirror *mirrorRequest) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Warningf(ctx, "【mirror】panic:%v", err)
+		}
+	}()
+
+	shadowReq, err := http.NewRequestWithContext(ctx, mirror.method, shadowURL+mirror.uri, bytes.NewReader(mirror.body))
+	if err != nil {
+		log.Warningf(ctx, "【mirror】new request err:%v", err)
+		return
+	}
+
+	for key, values := range mirror.header {
+		// trace headers are written again by the client from ctx
+		if key == "Connection" || key == "Content-Length" || key == context0.HttpHeaderTraceID ||
+			strings.HasPrefix(key, context0.HttpHeaderMetaPrefix) || mirrorDropHeaders[key] {
+			continue
+		}
+		shadowReq.Header[key] = values
+	}
+	shadowReq.Header.Set(HttpHeaderMirror, "1")
+
+	now := time.Now()
+	shadowResp, err := c.client.Do(ctx, shadowReq, http_client.CallNoRetry())
+	if err != nil {
+		log.Warningf(ctx, "【mirror】%s %s err:%v", mirror.method, mirror.uri, err)
+		return
+	}
+	defer shadowResp.Body.Close()
+
+	shadowBody, _ := io.ReadAll(io.LimitReader(shadowResp.Body, int64(c.maxBody)+1))
+	shadowTruncated := len(shadowBody) > c.maxBody
+	cost := time.Since(now).Milliseconds()
+
+	if !c.compare {
+		return
+	}
+
+	if mirror.status != shadowResp.StatusCode {
+		log.Warningf(ctx, "【mirror】diff %s %s status primary:%d shadow:%d cost:%v(ms)",
+			mirror.method, mirror.uri, mirror.status, shadowResp.StatusCode, cost)
+		return
+	}
+
+	if mirror.truncated || shadowTruncated {
+		log.Infof(ctx, "【mirror】%s %s body over %d bytes not compared cost:%v(ms)", mirror.method, mirror.uri, c.maxBody, cost)
+		return
+	}
+
+	if !sameBody(mirror.respBody, shadowBody) {
+		log.Warningf(ctx, "【mirror】diff %s %s body primary:%s shadow:%s cost:%v(ms)",
+			mirror.method, mirror.uri, c.logText(mirror.respBody), c.logText(shadowBody), cost)
+		return
+	}
+
+	log.Debugf(ctx, "【mirror】same %s %s cost:%v(ms)", mirror.method, mirror.uri, cost)
+}
+
+// logText redacts and truncates a body for the diff log
+func (c *mirrorConf) logText(body []byte) string {
+	if len(body) > c.bodyLog.maxSize {
+		return c.bodyLog.bodyText("", body[:c.bodyLog.maxSize], true)
+	}
+
+	return c.bodyLog.bodyText("", body, false)
+}
+
+// sameBody compares json bodies ignoring field order and spaces, others byte by byte
+func sameBody(primary, shadow []byte) bool {
+	if bytes.Equal(primary, shadow) {
+		return true
+	}
+
+	var primaryValue, shadowValue interface{}
+	if json.Unmarshal(primary, &primaryValue) != nil || json.Unmarshal(shadow, &shadowValue) != nil {
+		return false
+	}
+
+	return reflect.DeepEqual(primaryValue, shadowValue)
+}
@@ -0,0 +1,168 @@
+package http_server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type shadowRequest struct {
+	header http.Header
+	body   string
+}
+
+// newShadowServer replies reply to every request and hands the requests to the returned channel
+func newShadowServer(t *testing.T, reply string) (*httptest.Server, chan shadowRequest) {
+	received := make(chan shadowRequest, 16)
+	shadow := httptest.NewServer(http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		_, _ = rsp.Write([]byte(reply))
+		received <- shadowRequest{header: req.Header, body: string(body)}
+	}))
+	t.Cleanup(shadow.Close)
+
+	return shadow, received
+}
+
+// waitLogs polls logs until one line is logged or the deadline passes
+func waitLogs(logs func() []string) []string {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if lines := logs(); len(lines) > 0 {
+			return lines
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	return logs()
+}
+
+func TestMirror(t *testing.T) {
+	shadow, received := newShadowServer(t, `{"b":2, "a":1}`)
+
+	server := New("test")
+	server.Post("/orders", Chain(func(ctx context.Context, resp *Response, req *Request) {
+		body, _ := io.ReadAll(req.Body)
+		_, _ = resp.Write([]byte(`{"a":1,"b":2}`))
+		if string(body) != "order-1" {
+			t.Errorf("primary handler got body %q", body)
+		}
+	}, Mirror(shadow.URL, MirrorMethods(http.MethodPost))), nil)
+
+	logs := captureLogs("【mirror】")
+	req := httptest.NewRequest(http.MethodPost, "/orders?id=1", strings.NewReader("order-1"))
+	req.Header.Set("X-Custom", "c1")
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+
+	if recorder.Body.String() != `{"a":1,"b":2}` {
+		t.Fatalf("primary reply %q", recorder.Body.String())
+	}
+
+	select {
+	case got := <-received:
+		if got.body != "order-1" || got.header.Get(HttpHeaderMirror) != "1" || got.header.Get("X-Custom") != "c1" {
+			t.Fatalf("shadow got body %q header %v", got.body, got.header)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("shadow request not sent")
+	}
+
+	if lines := waitLogs(logs); len(lines) != 1 || !strings.Contains(lines[0], "same POST /orders?id=1") {
+		t.Fatalf("got logs %q, want json bodies to compare equal", lines)
+	}
+}
+
+func TestMirrorDiff(t *testing.T) {
+	shadow, received := newShadowServer(t, "v2")
+
+	server := New("test")
+	server.Get("/version", Chain(replyText("v1"), Mirror(shadow.URL)), nil)
+
+	logs := captureLogs("【mirror】diff")
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/version", nil))
+	<-received
+
+	if lines := waitLogs(logs); len(lines) != 1 || !strings.Contains(lines[0], "body primary:v1 shadow:v2") {
+		t.Fatalf("got logs %q", lines)
+	}
+}
+
+func TestMirrorSkipped(t *testing.T) {
+	shadow, received := newShadowServer(t, "ok")
+
+	server := New("test")
+	server.Post("/upload", Chain(func(ctx context.Context, resp *Response, req *Request) {
+		body, _ := io.ReadAll(req.Body)
+		_, _ = resp.Write(body)
+	}, Mirror(shadow.URL, MirrorMaxBody(4), MirrorMethods(http.MethodPost))), nil)
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader("too large")))
+	if recorder.Body.String() != "too large" {
+		t.Fatalf("primary handler got body %q", recorder.Body.String())
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader("ok"))
+	req.Header.Set(HttpHeaderMirror, "1")
+	server.ServeHTTP(httptest.NewRecorder(), req)
+
+	select {
+	case got := <-received:
+		t.Fatalf("shadow got %q", got.body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMirrorSafeMethods(t *testing.T) {
+	shadow, received := newShadowServer(t, "ok")
+
+	server := New("test")
+	server.Post("/orders", Chain(replyText("ok"), Mirror(shadow.URL)), nil)
+	server.Get("/orders", Chain(replyText("ok"), Mirror(shadow.URL)), nil)
+
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader("order-1")))
+	select {
+	case got := <-received:
+		t.Fatalf("POST mirrored by default, shadow got %q", got.body)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Proxy-Authorization", "Basic secret")
+	req.Header.Set("Cookie", "session=secret")
+	req.Header.Set("X-Custom", "c1")
+	server.ServeHTTP(httptest.NewRecorder(), req)
+
+	select {
+	case got := <-received:
+		if got.header.Get("Authorization") != "" || got.header.Get("Proxy-Authorization") != "" ||
+			got.header.Get("Cookie") != "" || got.header.Get("X-Custom") != "c1" {
+			t.Fatalf("shadow got header %v", got.header)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("GET not mirrored")
+	}
+}
+
+func TestMirrorDiffLogTruncated(t *testing.T) {
+	shadow, received := newShadowServer(t, `{"token":"shadow-secret","name":"b"}`)
+
+	server := New("test")
+	server.Get("/user", Chain(replyText(`{"token":"primary-secret","name":"a"}`),
+		Mirror(shadow.URL, MirrorLogMaxBody(24), MirrorRedactFields("Token"))), nil)
+
+	logs := captureLogs("【mirror】diff")
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/user", nil))
+	<-received
+
+	lines := waitLogs(logs)
+	if len(lines) != 1 || strings.Contains(lines[0], "secret") || !strings.Contains(lines[0], "(truncated at 24 bytes)") {
+		t.Fatalf("got logs %q", lines)
+	}
+}
@@ -0,0 +1,192 @@
+package http_server
+
+import (
+	"context"
+	"fmt"
+	"github.com/RealJonathanYip/framework/context0"
+	"github.com/RealJonathanYip/framework/log"
+	"github.com/RealJonathanYip/framework/overflow"
+	"github.com/pkg/errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimit limits the QPS of every value returned by Key, requests with an empty key are not limited
+type RateLimit struct {
+	Name string
+	Key  func(ctx context.Context, req *Request) string
+	QPS  uint32
+}
+
+var _rateLimitScope uint32
+
+// LimitByClientIP limits each client ip, see TrustProxies for requests coming through proxies
+func LimitByClientIP(qps uint32) RateLimit {
+	return RateLimit{
+		Name: "ip",
+		Key: func(ctx context.Context, req *Request) string {
+			return req.ClientIP()
+		},
+		QPS: qps,
+	}
+}
+
+func LimitByHeader(name string, qps uint32) RateLimit {
+	return RateLimit{
+		Name: "header." + name,
+		Key: func(ctx context.Context, req *Request) string {
+			return req.Header.Get(name)
+		},
+		QPS: qps,
+	}
+}
+
+// LimitByContext limits each value of a context0 key, e.g. the user id set by an OnBeforeRequest auth hook
+func LimitByContext(key string, qps uint32) RateLimit {
+	return RateLimit{
+		Name: "ctx." + key,
+		Key: func(ctx context.Context, req *Request) string {
+			value, _ := context0.Get(ctx, key)
+			return value
+		},
+		QPS: qps,
+	}
+}
+
+// RateLimiter checks the limits in order and replies 429 with Retry-After and RateLimit-* headers on overflow,
+// each RateLimiter has its own counters so it can be applied to one route by Chain or to all by Use,
+// it runs after the OnBeforeRequest hooks so authenticated users can be limited
+func RateLimiter(limits ...RateLimit) Middleware {
+	scope := fmt.Sprintf("ratelimit.%d.", atomic.AddUint32(&_rateLimitScope, 1))
+
+	return func(handler func(context.Context, *Response, *Request)) func(context.Context, *Response, *Request) {
+		return func(ctx context.Context, resp *Response, req *Request) {
+			remainMin, limitMin, resetMax := uint32(0), uint32(0), time.Duration(0)
+			for i, limit := range limits {
+				key := limit.Key(ctx, req)
+				if key == "" {
+					continue
+				}
+
+				bOverFlow, remain, resetIn := overflow.Take(scope+strconv.Itoa(i)+"."+limit.Name+"."+key, limit.QPS)
+				if bOverFlow {
+					log.Warningf(ctx, "rate limit:%s key:%s over %d qps", limit.Name, key, limit.QPS)
+					setRateLimitHeader(resp.Header(), limit.QPS, 0, resetIn)
+					resp.Header().Set("Retry-After", retryAfterSeconds(resetIn))
+					_ = resp.ReplyJsonWithStatus(ctx, ERROR_TOO_MANY_REQUESTS, &Reply{
+						Result: ERROR_TOO_MANY_REQUESTS,
+						Msg:    "too many requests, please try again later",
+					})
+					return
+				}
+
+				if limitMin == 0 || remain < remainMin {
+					remainMin, limitMin = remain, limit.QPS
+				}
+				if resetIn > resetMax {
+					resetMax = resetIn
+				}
+			}
+
+			if limitMin > 0 {
+				setRateLimitHeader(resp.Header(), limitMin, remainMin, resetMax)
+			}
+
+			handler(ctx, resp, req)
+		}
+	}
+}
+
+func setRateLimitHeader(header http.Header, limit, remain uint32, resetIn time.Duration) {
+	header.Set("RateLimit-Limit", strconv.FormatUint(uint64(limit), 10))
+	header.Set("RateLimit-Remaining", strconv.FormatUint(uint64(remain), 10))
+	header.Set("RateLimit-Reset", retryAfterSeconds(resetIn))
+}
+
+func retryAfterSeconds(wait time.Duration) string {
+	seconds := int64((wait + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	return strconv.FormatInt(seconds, 10)
+}
+
+// TrustProxies sets the proxies (ip or cidr) whose X-Forwarded-For is honoured by Request.ClientIP,
+// it must be called before Run
+func (h *HttpServer) TrustProxies(proxies ...string) error {
+	trusted := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return errors.Errorf("invalid proxy ip:%s", proxy)
+			}
+
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			proxy = fmt.Sprintf("%s/%d", proxy, bits)
+		}
+
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return errors.Wrapf(err, "invalid proxy cidr:%s", proxy)
+		}
+		trusted = append(trusted, ipNet)
+	}
+
+	h.trustedProxies = trusted
+	return nil
+}
+
+func (h *HttpServer) isTrustedProxy(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+
+	for _, ipNet := range h.trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ClientIP returns the remote address, or the nearest untrusted address of X-Forwarded-For
+// when the request comes from a trusted proxy
+func (r *Request) ClientIP() string {
+	remoteIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remoteIP = r.RemoteAddr
+	}
+
+	if r.server == nil || !r.server.isTrustedProxy(remoteIP) {
+		return remoteIP
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwarded[i])
+		if ip == "" {
+			continue
+		}
+
+		if !r.server.isTrustedProxy(ip) || i == 0 {
+			return ip
+		}
+	}
+
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+		return realIP
+	}
+
+	return remoteIP
+}
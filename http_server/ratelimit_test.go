@@ -0,0 +1,75 @@
+package http_server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// waitSecondStart keeps the requests of a test inside one overflow counting second
+func waitSecondStart() {
+	if elapsed := time.Duration(time.Now().Nanosecond()); elapsed > 500*time.Millisecond {
+		time.Sleep(time.Second - elapsed)
+	}
+}
+
+func TestRateLimitByClientIP(t *testing.T) {
+	server := New("test")
+	server.Get("/limited", Chain(replyText("ok"), RateLimiter(LimitByClientIP(2))), nil)
+
+	serve := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/limited", nil)
+		req.RemoteAddr = remoteAddr
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	waitSecondStart()
+	first := serve("10.0.0.1:1000")
+	if first.Code != http.StatusOK || first.Header().Get("RateLimit-Limit") != "2" || first.Header().Get("RateLimit-Remaining") != "1" {
+		t.Fatalf("got status %d header %v", first.Code, first.Header())
+	}
+	if recorder := serve("10.0.0.1:1001"); recorder.Code != http.StatusOK {
+		t.Fatalf("second request got status %d", recorder.Code)
+	}
+
+	limited := serve("10.0.0.1:1002")
+	if limited.Code != http.StatusTooManyRequests || limited.Header().Get("Retry-After") != "1" {
+		t.Fatalf("third request got status %d Retry-After %q", limited.Code, limited.Header().Get("Retry-After"))
+	}
+
+	if recorder := serve("10.0.0.2:1000"); recorder.Code != http.StatusOK {
+		t.Fatalf("other client got status %d", recorder.Code)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	server := New("test")
+	if err := server.TrustProxies("10.0.0.0/8", "192.168.1.1"); err != nil {
+		t.Fatalf("TrustProxies err:%v", err)
+	}
+	if err := server.TrustProxies("proxy.local"); err == nil {
+		t.Fatal("invalid proxy accepted")
+	}
+
+	clientIP := func(remoteAddr, forwardedFor string) string {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		return (&Request{Request: req, server: server}).ClientIP()
+	}
+
+	if got := clientIP("1.2.3.4:80", "5.6.7.8"); got != "1.2.3.4" {
+		t.Errorf("untrusted remote got %q", got)
+	}
+	if got := clientIP("10.1.1.1:80", "9.9.9.9, 5.6.7.8, 192.168.1.1"); got != "5.6.7.8" {
+		t.Errorf("trusted chain got %q", got)
+	}
+	if got := clientIP("10.1.1.1:80", "10.2.2.2"); got != "10.2.2.2" {
+		t.Errorf("only trusted hops got %q", got)
+	}
+}
@@ -1,6 +1,7 @@
 package http_server
 
 import (
+	"context"
 	"github.com/bytedance/go-tagexpr/v2/binding"
 	"net/http"
 	"net/url"
@@ -20,6 +21,26 @@ type formGetter struct {
 
 type Request struct {
 	*http.Request
+	server          *HttpServer
+	listener        string
+	apiVersion      string
+	debugLog        bool
+	overFlowHandler func(context.Context, *Response, *Request)
+}
+
+// Listener is the name of the listener which accepted the request, DEFAULT_LISTENER for Run's own port
+func (r *Request) Listener() string {
+	return r.listener
+}
+
+// OverFlow replies with the overflow handler of the route, or 503 when the route has none
+func (r *Request) OverFlow(ctx context.Context, resp *Response) {
+	if r.overFlowHandler != nil {
+		r.overFlowHandler(ctx, resp, r)
+		return
+	}
+
+	http.Error(resp, "server busy!  plase try again later", http.StatusServiceUnavailable)
 }
 
 func (r *Request) ParamsFromQuery(params interface{}) error {
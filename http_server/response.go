@@ -2,17 +2,27 @@ package http_server
 
 import (
 	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
 	"github.com/RealJonathanYip/framework/log"
 	"net/http"
+	"strings"
+	"time"
 )
 
 type Response struct {
 	http.ResponseWriter
 	onBeforeReply func(context.Context, *Response)
+	request       *Request
 }
 
 func (r *Response) ReplyJson(ctx context.Context, data interface{}) error {
+	return r.ReplyJsonWithStatus(ctx, http.StatusOK, data)
+}
+
+// ReplyJsonWithStatus replies data with a status other than 200, preconditions are only checked for 200
+func (r *Response) ReplyJsonWithStatus(ctx context.Context, status int, data interface{}) error {
 	byteData, ok := data.([]byte)
 	if !ok {
 		byteDataTemp, err := json.Marshal(data)
@@ -25,8 +35,21 @@ func (r *Response) ReplyJson(ctx context.Context, data interface{}) error {
 	}
 
 	r.Header().Set("content-type", "application/json; charset=utf-8")
+	if status == http.StatusOK && r.isConditionalMethod() && r.Header().Get("ETag") == "" {
+		r.Header().Set("ETag", computeETag(byteData))
+	}
 	r.onBeforeReply(ctx, r)
 
+	if status == http.StatusOK && r.isNotModified() {
+		r.Header().Del("content-type")
+		r.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	if status != http.StatusOK {
+		r.WriteHeader(status)
+	}
+
 	if _, err := r.Write(byteData); err != nil {
 		log.Warningf(ctx, "http reply err!:%v", err)
 		return err
@@ -34,3 +57,103 @@ func (r *Response) ReplyJson(ctx context.Context, data interface{}) error {
 
 	return nil
 }
+
+// BeforeReply runs the OnBeforeReply hooks, handlers writing the body by themselves call it before the first write
+func (r *Response) BeforeReply(ctx context.Context) {
+	r.onBeforeReply(ctx, r)
+}
+
+// SetETag sets the entity tag of the response, ReplyJson will not compute one when it is set
+func (r *Response) SetETag(etag string, weak ...bool) {
+	if !strings.HasPrefix(etag, "\"") && !strings.HasPrefix(etag, "W/\"") {
+		etag = "\"" + etag + "\""
+	}
+
+	if len(weak) > 0 && weak[0] && !strings.HasPrefix(etag, "W/") {
+		etag = "W/" + etag
+	}
+
+	r.Header().Set("ETag", etag)
+}
+
+func (r *Response) SetLastModified(modTime time.Time) {
+	if modTime.IsZero() || modTime.Equal(time.Unix(0, 0)) {
+		return
+	}
+
+	r.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+}
+
+// CheckNotModified replies 304 if the request preconditions match the ETag or Last-Modified already set,
+// handlers can call it before building an expensive reply and return directly when it is true
+func (r *Response) CheckNotModified(ctx context.Context) bool {
+	if !r.isNotModified() {
+		return false
+	}
+
+	r.onBeforeReply(ctx, r)
+	r.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+func (r *Response) isConditionalMethod() bool {
+	if r.request == nil {
+		return false
+	}
+
+	return r.request.Method == _METHOD_GET || r.request.Method == _METHOD_HEAD
+}
+
+func (r *Response) isNotModified() bool {
+	if !r.isConditionalMethod() {
+		return false
+	}
+
+	return checkNotModified(r.request.Request, r.Header())
+}
+
+// checkNotModified evaluates If-None-Match and If-Modified-Since against the response header (RFC 7232)
+func checkNotModified(req *http.Request, header http.Header) bool {
+	if ifNoneMatch := req.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		etag := header.Get("ETag")
+		if etag == "" {
+			return false
+		}
+
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || weakETag(candidate) == weakETag(etag) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	ifModifiedSince := req.Header.Get("If-Modified-Since")
+	lastModified := header.Get("Last-Modified")
+	if ifModifiedSince == "" || lastModified == "" {
+		return false
+	}
+
+	sinceTime, err := http.ParseTime(ifModifiedSince)
+	if err != nil {
+		return false
+	}
+
+	modTime, err := http.ParseTime(lastModified)
+	if err != nil {
+		return false
+	}
+
+	return !modTime.Truncate(time.Second).After(sinceTime)
+}
+
+func weakETag(etag string) string {
+	return strings.TrimPrefix(etag, "W/")
+}
+
+func computeETag(data []byte) string {
+	sum := sha1.Sum(data)
+	return "W/\"" + hex.EncodeToString(sum[:]) + "\""
+}
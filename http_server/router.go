@@ -0,0 +1,259 @@
+package http_server
+
+import (
+	"context"
+	"github.com/RealJonathanYip/framework/context0"
+	"github.com/RealJonathanYip/framework/log"
+	"github.com/pkg/errors"
+	"net"
+	"sort"
+	"strings"
+)
+
+// routeScope restricts a route to a host pattern and/or a listener, empty means any
+type routeScope struct {
+	host     string
+	listener string
+}
+
+func (s routeScope) String() string {
+	if s.host == "" && s.listener == "" {
+		return ""
+	}
+
+	return s.host + "@" + s.listener + ":"
+}
+
+type prefixRoute struct {
+	scope   routeScope
+	prefix  string
+	method  string
+	handler func(context.Context, *Response, *Request)
+}
+
+// routeTable is never modified after it is published, writers clone it under routerLock and swap
+type routeTable struct {
+	exact  map[string]func(context.Context, *Response, *Request)
+	prefix []*prefixRoute
+	// hosts are the host patterns used by routes, exact hosts first then wildcards from the longest
+	hosts []string
+}
+
+func newRouteTable() *routeTable {
+	return &routeTable{
+		exact:  make(map[string]func(context.Context, *Response, *Request)),
+		prefix: make([]*prefixRoute, 0),
+		hosts:  make([]string, 0),
+	}
+}
+
+func (t *routeTable) clone() *routeTable {
+	table := &routeTable{
+		exact:  make(map[string]func(context.Context, *Response, *Request), len(t.exact)),
+		prefix: make([]*prefixRoute, len(t.prefix)),
+		hosts:  make([]string, len(t.hosts)),
+	}
+	for key, handler := range t.exact {
+		table.exact[key] = handler
+	}
+	copy(table.prefix, t.prefix)
+	copy(table.hosts, t.hosts)
+
+	return table
+}
+
+func (t *routeTable) addHost(host string) {
+	if host == "" {
+		return
+	}
+
+	for _, hostTemp := range t.hosts {
+		if hostTemp == host {
+			return
+		}
+	}
+
+	t.hosts = append(t.hosts, host)
+	sort.SliceStable(t.hosts, func(i, j int) bool {
+		iWildcard, jWildcard := strings.HasPrefix(t.hosts[i], "*."), strings.HasPrefix(t.hosts[j], "*.")
+		if iWildcard != jWildcard {
+			return !iWildcard
+		}
+
+		return len(t.hosts[i]) > len(t.hosts[j])
+	})
+}
+
+// resolveHosts returns the registered host patterns matching the request host, exact hosts first
+// then wildcards from the longest, so a route missing on a host falls back to the wider patterns
+func (t *routeTable) resolveHosts(requestHost string) []string {
+	if len(t.hosts) == 0 {
+		return nil
+	}
+
+	if host, _, err := net.SplitHostPort(requestHost); err == nil {
+		requestHost = host
+	}
+	requestHost = strings.ToLower(requestHost)
+
+	hosts := make([]string, 0, 1)
+	for _, host := range t.hosts {
+		if host == requestHost || strings.HasPrefix(host, "*.") && strings.HasSuffix(requestHost, host[1:]) {
+			hosts = append(hosts, host)
+		}
+	}
+
+	return hosts
+}
+
+func (t *routeTable) match(host, listener, path, method string) (func(context.Context, *Response, *Request), bool) {
+	hosts := append(t.resolveHosts(host), "")
+	for _, hostTemp := range hosts {
+		for _, scope := range []routeScope{{hostTemp, listener}, {hostTemp, ""}} {
+			if fnHandler, bExist := t.exact[scope.String()+path+"_"+method]; bExist {
+				return fnHandler, true
+			}
+		}
+	}
+
+	// the longest prefix wins, then the most specific scope among the routes of that prefix
+	var best *prefixRoute
+	bestRank := 0
+	for _, route := range t.prefix {
+		if best != nil && len(route.prefix) < len(best.prefix) {
+			break
+		}
+		if route.method != method || !strings.HasPrefix(path, route.prefix) ||
+			route.scope.listener != "" && route.scope.listener != listener {
+			continue
+		}
+
+		for i, hostTemp := range hosts {
+			if route.scope.host != hostTemp {
+				continue
+			}
+
+			rank := i * 2
+			if route.scope.listener == "" {
+				rank++
+			}
+			if best == nil || rank < bestRank {
+				best, bestRank = route, rank
+			}
+			break
+		}
+	}
+
+	if best == nil {
+		return nil, false
+	}
+
+	return best.handler, true
+}
+
+func (h *HttpServer) routeTable() *routeTable {
+	return h.routes.Load().(*routeTable)
+}
+
+func (h *HttpServer) match(host, listener, path, method string) (func(context.Context, *Response, *Request), bool) {
+	return h.routeTable().match(host, listener, path, method)
+}
+
+// updateRoutes applies fnUpdate to a copy of the route table and publishes it when no error is returned
+func (h *HttpServer) updateRoutes(fnUpdate func(table *routeTable) error) error {
+	h.routerLock.Lock()
+	defer h.routerLock.Unlock()
+
+	table := h.routeTable().clone()
+	if err := fnUpdate(table); err != nil {
+		return err
+	}
+
+	h.routes.Store(table)
+	return nil
+}
+
+func (h *HttpServer) doRegisterHttpHandler(scope routeScope, path, method string, handler, overFlowHandler func(context.Context, *Response, *Request), maxQPS ...uint32) error {
+	key := scope.String() + path + "_" + method
+	fnHandler := h.wrapHttpHandler(scope.String()+path, method, handler, overFlowHandler, maxQPS...)
+	err := h.updateRoutes(func(table *routeTable) error {
+		if _, bExist := table.exact[key]; bExist {
+			return errors.Errorf("http uri:%s exist!", scope.String()+method+"."+path)
+		}
+
+		table.exact[key] = fnHandler
+		table.addHost(scope.host)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Infof(context0.NewContext(), "register http router : %v", key)
+	return nil
+}
+
+// doRegisterPrefixHandler routes every path starting with prefix, exact routes win and longer prefixes are matched first
+func (h *HttpServer) doRegisterPrefixHandler(scope routeScope, prefix, method string, handler, overFlowHandler func(context.Context, *Response, *Request), maxQPS ...uint32) error {
+	route := &prefixRoute{
+		scope:   scope,
+		prefix:  prefix,
+		method:  method,
+		handler: h.wrapHttpHandler(scope.String()+prefix, method, handler, overFlowHandler, maxQPS...),
+	}
+	err := h.updateRoutes(func(table *routeTable) error {
+		for _, routeTemp := range table.prefix {
+			if routeTemp.scope == scope && routeTemp.prefix == prefix && routeTemp.method == method {
+				return errors.Errorf("http prefix:%s exist!", scope.String()+method+"."+prefix)
+			}
+		}
+
+		table.prefix = append(table.prefix, route)
+		sort.SliceStable(table.prefix, func(i, j int) bool {
+			return len(table.prefix[i].prefix) > len(table.prefix[j].prefix)
+		})
+		table.addHost(scope.host)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Infof(context0.NewContext(), "register http prefix router : %v", scope.String()+prefix+"*_"+method)
+	return nil
+}
+
+func (h *HttpServer) doRemove(scope routeScope, path, method string, isPrefix bool) bool {
+	// the versions of the path are registered again from scratch after their shared route is removed
+	h.versionLock.Lock()
+	defer h.versionLock.Unlock()
+
+	errNotFound := errors.New("route not found")
+	err := h.updateRoutes(func(table *routeTable) error {
+		if !isPrefix {
+			key := scope.String() + path + "_" + method
+			if _, bExist := table.exact[key]; !bExist {
+				return errNotFound
+			}
+
+			delete(table.exact, key)
+			delete(h.versionDispatchers, versionKey(scope, method, path))
+			return nil
+		}
+
+		for i, route := range table.prefix {
+			if route.scope == scope && route.prefix == path && route.method == method {
+				table.prefix = append(table.prefix[:i], table.prefix[i+1:]...)
+				return nil
+			}
+		}
+
+		return errNotFound
+	})
+	if err != nil {
+		return false
+	}
+
+	log.Infof(context0.NewContext(), "remove http router : %v", scope.String()+path+"_"+method)
+	return true
+}
@@ -0,0 +1,189 @@
+package http_server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func replyText(text string) func(context.Context, *Response, *Request) {
+	return func(ctx context.Context, resp *Response, req *Request) {
+		_, _ = resp.Write([]byte(text))
+	}
+}
+
+func TestRemoveRoute(t *testing.T) {
+	server := New("test")
+	server.Get("/users", replyText("users"), nil)
+	server.HandlePrefix(http.MethodGet, "/files/", replyText("files"), nil)
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder
+	}
+
+	if recorder := serve("/files/a.txt"); recorder.Body.String() != "files" {
+		t.Fatalf("prefix route got %d %q", recorder.Code, recorder.Body.String())
+	}
+
+	if !server.Remove(http.MethodGet, "/users") {
+		t.Fatal("Remove of a registered route returned false")
+	}
+	if server.Remove(http.MethodGet, "/users") {
+		t.Fatal("Remove of a removed route returned true")
+	}
+	if recorder := serve("/users"); recorder.Code != http.StatusNotFound {
+		t.Fatalf("removed route got status %d", recorder.Code)
+	}
+
+	server.Get("/users", replyText("users v2"), nil)
+	if recorder := serve("/users"); recorder.Body.String() != "users v2" {
+		t.Fatalf("re-registered route got %q", recorder.Body.String())
+	}
+
+	if server.Remove(http.MethodGet, "/files/") || !server.RemovePrefix(http.MethodGet, "/files/") {
+		t.Fatal("prefix routes are only removed by RemovePrefix")
+	}
+	if recorder := serve("/files/a.txt"); recorder.Code != http.StatusNotFound {
+		t.Fatalf("removed prefix route got status %d", recorder.Code)
+	}
+}
+
+func TestDuplicateRoutePanics(t *testing.T) {
+	server := New("test")
+	server.Get("/users", replyText("users"), nil)
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("registering a route twice did not panic")
+		}
+	}()
+	server.Get("/users", replyText("users"), nil)
+}
+
+func TestRegisterWhileServing(t *testing.T) {
+	server := New("test")
+	server.Get("/stable", replyText("stable"), nil)
+
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			path := fmt.Sprintf("/dynamic/%d", i)
+			server.Get(path, replyText(path), nil)
+			server.Remove(http.MethodGet, path)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/stable", nil))
+			if recorder.Body.String() != "stable" {
+				t.Errorf("got %d %q while routes change", recorder.Code, recorder.Body.String())
+				return
+			}
+		}
+	}()
+	wg.Wait()
+}
+
+func TestHostRoutes(t *testing.T) {
+	server := New("test")
+	server.Get("/", replyText("any"), nil)
+	server.Host("api.example.com").Get("/", replyText("api"), nil)
+	server.Host("*.example.com").Get("/", replyText("wildcard"), nil)
+	server.Host("admin.example.com").Get("/only", replyText("admin"), nil)
+
+	serve := func(host, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Host = host
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	if got := serve("API.example.com:8080", "/").Body.String(); got != "api" {
+		t.Errorf("exact host got %q", got)
+	}
+	if got := serve("www.example.com", "/").Body.String(); got != "wildcard" {
+		t.Errorf("sub domain got %q", got)
+	}
+	if got := serve("example.org", "/").Body.String(); got != "any" {
+		t.Errorf("other host got %q", got)
+	}
+	if recorder := serve("www.example.com", "/only"); recorder.Code != http.StatusNotFound {
+		t.Errorf("route of another host got status %d", recorder.Code)
+	}
+}
+
+func TestHostRoutesFallback(t *testing.T) {
+	server := New("test")
+	server.Get("/any", replyText("any"), nil)
+	server.Host("*.example.com").Get("/", replyText("wildcard"), nil)
+	server.Host("*.example.com").HandlePrefix(http.MethodGet, "/static/", replyText("wildcard static"), nil)
+	server.Host("admin.example.com").Get("/only", replyText("admin"), nil)
+
+	serve := func(path string) string {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Host = "admin.example.com"
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		return recorder.Body.String()
+	}
+
+	if got := serve("/only"); got != "admin" {
+		t.Errorf("exact host route got %q", got)
+	}
+	if got := serve("/"); got != "wildcard" {
+		t.Errorf("route missing on the exact host got %q, want the wildcard one", got)
+	}
+	if got := serve("/static/app.js"); got != "wildcard static" {
+		t.Errorf("prefix route missing on the exact host got %q", got)
+	}
+	if got := serve("/any"); got != "any" {
+		t.Errorf("route of any host got %q", got)
+	}
+}
+
+func TestListenerRoutes(t *testing.T) {
+	server := New("test")
+	server.Listen("admin", "127.0.0.1:0", func(next func(context.Context, *Response, *Request)) func(context.Context, *Response, *Request) {
+		return func(ctx context.Context, resp *Response, req *Request) {
+			resp.Header().Set("X-Listener", req.Listener())
+			next(ctx, resp, req)
+		}
+	})
+	server.Get("/health", replyText("ok"), nil)
+	server.Listener("admin").Get("/metrics", replyText("metrics"), nil)
+
+	serve := func(listener, path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.onReq(recorder, httptest.NewRequest(http.MethodGet, path, nil), listener)
+		return recorder
+	}
+
+	if recorder := serve("admin", "/metrics"); recorder.Body.String() != "metrics" || recorder.Header().Get("X-Listener") != "admin" {
+		t.Fatalf("admin listener got %q header %v", recorder.Body.String(), recorder.Header())
+	}
+	if recorder := serve(DEFAULT_LISTENER, "/metrics"); recorder.Code != http.StatusNotFound {
+		t.Fatalf("default listener served an admin route, status %d", recorder.Code)
+	}
+
+	recorder := serve(DEFAULT_LISTENER, "/health")
+	if recorder.Body.String() != "ok" || recorder.Header().Get("X-Listener") != "" {
+		t.Fatalf("default listener got %q, the admin middleware leaked: %v", recorder.Body.String(), recorder.Header())
+	}
+	if recorder = serve("admin", "/health"); recorder.Body.String() != "ok" {
+		t.Fatalf("unscoped route on admin listener got %q", recorder.Body.String())
+	}
+
+	if _, err := server.ListenerAddr("admin"); err != errListenerNotFound {
+		t.Fatalf("ListenerAddr before Run err:%v", err)
+	}
+}
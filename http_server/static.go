@@ -0,0 +1,315 @@
+package http_server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"github.com/RealJonathanYip/framework/log"
+	"html"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type staticConf struct {
+	index         string
+	listDirectory bool
+	spaIndex      string
+	maxAge        time.Duration
+	precompressed bool
+	onOverFlow    func(context.Context, *Response, *Request)
+	maxQPS        []uint32
+}
+
+type staticOption interface {
+	apply(*staticConf)
+}
+
+type staticOptionFunc func(*staticConf)
+
+func (f staticOptionFunc) apply(conf *staticConf) {
+	f(conf)
+}
+
+// StaticIndex sets the file served for a directory, default index.html
+func StaticIndex(name string) staticOption {
+	return staticOptionFunc(func(conf *staticConf) {
+		conf.index = name
+	})
+}
+
+// StaticListDirectory lists directories without an index file instead of replying 404
+func StaticListDirectory(yes bool) staticOption {
+	return staticOptionFunc(func(conf *staticConf) {
+		conf.listDirectory = yes
+	})
+}
+
+// StaticSPA serves indexFile for unknown paths so the frontend router can handle them
+func StaticSPA(indexFile string) staticOption {
+	return staticOptionFunc(func(conf *staticConf) {
+		conf.spaIndex = indexFile
+	})
+}
+
+// StaticMaxAge sets Cache-Control max-age of assets, index files are always served with no-cache
+func StaticMaxAge(maxAge time.Duration) staticOption {
+	return staticOptionFunc(func(conf *staticConf) {
+		conf.maxAge = maxAge
+	})
+}
+
+// StaticPrecompressed serves file.gz instead of file when the client accepts gzip, default true
+func StaticPrecompressed(yes bool) staticOption {
+	return staticOptionFunc(func(conf *staticConf) {
+		conf.precompressed = yes
+	})
+}
+
+func StaticOverFlow(fnOnOverFlow func(context.Context, *Response, *Request), maxQPS ...uint32) staticOption {
+	return staticOptionFunc(func(conf *staticConf) {
+		conf.onOverFlow = fnOnOverFlow
+		conf.maxQPS = maxQPS
+	})
+}
+
+type staticHandler struct {
+	prefix string
+	fsys   fs.FS
+	conf   staticConf
+	etags  sync.Map // served file name -> *staticETag
+}
+
+// staticETag is the content hash of a file, computed again when its ModTime or size changes
+type staticETag struct {
+	modTime time.Time
+	size    int64
+	etag    string
+}
+
+// Static serves files of fsys under prefix for GET and HEAD, Range and conditional requests are supported
+//
+// Example: server.Static("/", os.DirFS("./dist"), StaticSPA("index.html"), StaticMaxAge(time.Hour))
+func (h *HttpServer) Static(prefix string, fsys fs.FS, opts ...staticOption) {
+	h.doStatic(routeScope{}, prefix, fsys, opts...)
+}
+
+func (h *HttpServer) doStatic(scope routeScope, prefix string, fsys fs.FS, opts ...staticOption) {
+	conf := staticConf{
+		index:         "index.html",
+		precompressed: true,
+	}
+	for _, opt := range opts {
+		opt.apply(&conf)
+	}
+
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	handler := &staticHandler{prefix: prefix, fsys: fsys, conf: conf}
+	group := &RouteGroup{server: h, scope: scope}
+	group.HandlePrefix(_METHOD_GET, prefix, handler.serve, conf.onOverFlow, conf.maxQPS...)
+	group.HandlePrefix(_METHOD_HEAD, prefix, handler.serve, conf.onOverFlow, conf.maxQPS...)
+}
+
+func (h *HttpServer) StaticDir(prefix, dir string, opts ...staticOption) {
+	h.Static(prefix, os.DirFS(dir), opts...)
+}
+
+func (s *staticHandler) serve(ctx context.Context, resp *Response, req *Request) {
+	name := strings.TrimPrefix(path.Clean("/"+strings.TrimPrefix(req.URL.Path, s.prefix)), "/")
+	if name == "" {
+		name = "."
+	}
+
+	stat, err := fs.Stat(s.fsys, name)
+	if err != nil {
+		s.serveFallback(ctx, resp, req, name)
+		return
+	}
+
+	if !stat.IsDir() {
+		s.serveFile(ctx, resp, req, name, false)
+		return
+	}
+
+	if !strings.HasSuffix(req.URL.Path, "/") {
+		http.Redirect(resp, req.Request, req.URL.Path+"/", http.StatusMovedPermanently)
+		return
+	}
+
+	index := path.Join(name, s.conf.index)
+	if indexStat, err := fs.Stat(s.fsys, index); err == nil && !indexStat.IsDir() {
+		s.serveFile(ctx, resp, req, index, true)
+		return
+	}
+
+	if s.conf.listDirectory {
+		s.serveDirectory(ctx, resp, req, name)
+		return
+	}
+
+	s.serveFallback(ctx, resp, req, name)
+}
+
+// serveFallback serves the SPA index for page navigations and 404 for missing assets
+func (s *staticHandler) serveFallback(ctx context.Context, resp *Response, req *Request, name string) {
+	if s.conf.spaIndex != "" && (path.Ext(name) == "" || strings.Contains(req.Header.Get("Accept"), "text/html")) {
+		s.serveFile(ctx, resp, req, s.conf.spaIndex, true)
+		return
+	}
+
+	http.NotFound(resp, req.Request)
+}
+
+func (s *staticHandler) serveFile(ctx context.Context, resp *Response, req *Request, name string, isIndex bool) {
+	servedName := name
+	header := resp.Header()
+	if s.conf.precompressed {
+		header.Add("Vary", "Accept-Encoding")
+		if acceptGzip(req.Request) && req.Header.Get("Range") == "" {
+			if gzStat, err := fs.Stat(s.fsys, name+".gz"); err == nil && !gzStat.IsDir() {
+				servedName = name + ".gz"
+				header.Set("Content-Encoding", "gzip")
+			}
+		}
+	}
+
+	file, err := s.fsys.Open(servedName)
+	if err != nil {
+		log.Warningf(ctx, "open static file:%s fail:%v", servedName, err)
+		header.Del("Content-Encoding")
+		http.NotFound(resp, req.Request)
+		return
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil || stat.IsDir() {
+		header.Del("Content-Encoding")
+		http.NotFound(resp, req.Request)
+		return
+	}
+
+	content, ok := file.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(file)
+		if err != nil {
+			log.Warningf(ctx, "read static file:%s fail:%v", servedName, err)
+			http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		content = bytes.NewReader(data)
+	}
+
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+
+	if isIndex || s.conf.maxAge <= 0 {
+		header.Set("Cache-Control", "no-cache")
+	} else {
+		header.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int64(s.conf.maxAge/time.Second)))
+	}
+	etag, err := s.etag(servedName, stat, content)
+	if err != nil {
+		log.Warningf(ctx, "hash static file:%s fail:%v", servedName, err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	header.Set("ETag", etag)
+
+	resp.onBeforeReply(ctx, resp)
+	http.ServeContent(resp, req.Request, name, stat.ModTime(), content)
+}
+
+// etag hashes the content as files of embed.FS all have a zero ModTime, content is rewound after hashing
+func (s *staticHandler) etag(name string, stat fs.FileInfo, content io.ReadSeeker) (string, error) {
+	if value, exist := s.etags.Load(name); exist {
+		if cached := value.(*staticETag); cached.modTime.Equal(stat.ModTime()) && cached.size == stat.Size() {
+			return cached.etag, nil
+		}
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	etag := fmt.Sprintf("\"%x\"", hash.Sum(nil)[:16])
+	s.etags.Store(name, &staticETag{modTime: stat.ModTime(), size: stat.Size(), etag: etag})
+	return etag, nil
+}
+
+func (s *staticHandler) serveDirectory(ctx context.Context, resp *Response, req *Request, name string) {
+	entries, err := fs.ReadDir(s.fsys, name)
+	if err != nil {
+		log.Warningf(ctx, "read static dir:%s fail:%v", name, err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	buffer := bytes.Buffer{}
+	buffer.WriteString("<!doctype html>\n<meta name=\"viewport\" content=\"width=device-width\">\n<pre>\n")
+	for _, entry := range entries {
+		entryName := entry.Name()
+		if entry.IsDir() {
+			entryName += "/"
+		}
+		link := url.URL{Path: entryName}
+		buffer.WriteString(fmt.Sprintf("<a href=\"%s\">%s</a>\n", link.String(), html.EscapeString(entryName)))
+	}
+	buffer.WriteString("</pre>\n")
+
+	resp.Header().Set("Content-Type", "text/html; charset=utf-8")
+	resp.Header().Set("Cache-Control", "no-cache")
+	resp.onBeforeReply(ctx, resp)
+	if req.Method != _METHOD_HEAD {
+		_, _ = resp.Write(buffer.Bytes())
+	}
+}
+
+// acceptGzip reports whether Accept-Encoding gives gzip a q-value above 0, by name or else by *
+func acceptGzip(req *http.Request) bool {
+	gzipQuality, anyQuality := -1.0, -1.0
+	for _, encoding := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		params := strings.Split(encoding, ";")
+		quality := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if len(param) > 2 && strings.EqualFold(param[:2], "q=") {
+				value, err := strconv.ParseFloat(param[2:], 64)
+				if err != nil {
+					value = 0
+				}
+				quality = value
+			}
+		}
+
+		switch strings.ToLower(strings.TrimSpace(params[0])) {
+		case "gzip":
+			gzipQuality = quality
+		case "*":
+			anyQuality = quality
+		}
+	}
+
+	if gzipQuality >= 0 {
+		return gzipQuality > 0
+	}
+
+	return anyQuality > 0
+}
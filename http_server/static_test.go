@@ -0,0 +1,165 @@
+package http_server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func newStaticServer(t *testing.T, opts ...staticOption) *HttpServer {
+	root := t.TempDir()
+	public := filepath.Join(root, "public")
+	files := map[string]string{
+		filepath.Join(root, "secret.txt"):           "secret",
+		filepath.Join(public, "index.html"):         "<html>index</html>",
+		filepath.Join(public, "app.js"):             "console.log(1)",
+		filepath.Join(public, "docs", "readme.txt"): "0123456789",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := New("test")
+	server.StaticDir("/static", public, opts...)
+	return server
+}
+
+func serveStatic(server *HttpServer, method, uri string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, uri, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestStaticFile(t *testing.T) {
+	server := newStaticServer(t)
+
+	recorder := serveStatic(server, http.MethodGet, "/static/app.js")
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "console.log(1)" {
+		t.Fatalf("got status %d body %q", recorder.Code, recorder.Body.String())
+	}
+	if got := recorder.Header().Get("Content-Type"); got != "text/javascript; charset=utf-8" {
+		t.Errorf("got content type %q", got)
+	}
+
+	recorder = serveStatic(server, http.MethodGet, "/static/")
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "<html>index</html>" {
+		t.Fatalf("directory got status %d body %q, want the index", recorder.Code, recorder.Body.String())
+	}
+	if got := recorder.Header().Get("Cache-Control"); got != "no-cache" {
+		t.Errorf("index Cache-Control %q, want no-cache", got)
+	}
+
+	if recorder = serveStatic(server, http.MethodGet, "/static/docs"); recorder.Code != http.StatusMovedPermanently {
+		t.Errorf("directory without slash got status %d, want 301", recorder.Code)
+	}
+	if recorder = serveStatic(server, http.MethodGet, "/static/docs/"); recorder.Code != http.StatusNotFound {
+		t.Errorf("directory without index got status %d, want 404", recorder.Code)
+	}
+}
+
+func TestStaticHeadAndRange(t *testing.T) {
+	server := newStaticServer(t)
+
+	recorder := serveStatic(server, http.MethodHead, "/static/docs/readme.txt")
+	if recorder.Code != http.StatusOK || recorder.Body.Len() != 0 || recorder.Header().Get("Content-Length") != "10" {
+		t.Fatalf("HEAD got status %d body %q length %q", recorder.Code, recorder.Body.String(),
+			recorder.Header().Get("Content-Length"))
+	}
+
+	recorder = serveStatic(server, http.MethodGet, "/static/docs/readme.txt", "Range", "bytes=2-4")
+	if recorder.Code != http.StatusPartialContent || recorder.Body.String() != "234" {
+		t.Fatalf("range got status %d body %q", recorder.Code, recorder.Body.String())
+	}
+	if got := recorder.Header().Get("Content-Range"); got != "bytes 2-4/10" {
+		t.Errorf("got Content-Range %q", got)
+	}
+}
+
+func TestStaticNotModified(t *testing.T) {
+	server := newStaticServer(t)
+
+	etag := serveStatic(server, http.MethodGet, "/static/app.js").Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag sent")
+	}
+
+	recorder := serveStatic(server, http.MethodGet, "/static/app.js", "If-None-Match", etag)
+	if recorder.Code != http.StatusNotModified || recorder.Body.Len() != 0 {
+		t.Fatalf("got status %d body %q, want 304", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestStaticSPAFallback(t *testing.T) {
+	server := newStaticServer(t, StaticSPA("index.html"))
+
+	recorder := serveStatic(server, http.MethodGet, "/static/users/42")
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "<html>index</html>" {
+		t.Fatalf("page route got status %d body %q, want the index", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = serveStatic(server, http.MethodGet, "/static/users/42.json", "Accept", "text/html,*/*")
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "<html>index</html>" {
+		t.Fatalf("html navigation got status %d body %q, want the index", recorder.Code, recorder.Body.String())
+	}
+
+	if recorder = serveStatic(server, http.MethodGet, "/static/missing.js"); recorder.Code != http.StatusNotFound {
+		t.Fatalf("missing asset got status %d, want 404", recorder.Code)
+	}
+}
+
+func TestStaticPathTraversal(t *testing.T) {
+	server := newStaticServer(t, StaticSPA("index.html"))
+
+	for _, uri := range []string{"/static/../secret.txt", "/static/%2e%2e/secret.txt", "/static/docs/../../secret.txt"} {
+		recorder := serveStatic(server, http.MethodGet, uri)
+		if recorder.Body.String() == "secret" {
+			t.Errorf("%s served a file outside the root", uri)
+		}
+	}
+}
+
+func TestStaticETagFromContent(t *testing.T) {
+	// like embed.FS every file has a zero ModTime
+	fsys := fstest.MapFS{"app.js": {Data: []byte("console.log(1)")}}
+	server := New("test")
+	server.Static("/static", fsys)
+
+	etag := serveStatic(server, http.MethodGet, "/static/app.js").Header().Get("ETag")
+	if got := serveStatic(server, http.MethodGet, "/static/app.js").Header().Get("ETag"); etag == "" || got != etag {
+		t.Fatalf("got ETags %q and %q for the same content", etag, got)
+	}
+
+	fsys["app.js"].Data = []byte("console.log(2)")
+	fsys["app.js"].ModTime = fsys["app.js"].ModTime.Add(1)
+	recorder := serveStatic(server, http.MethodGet, "/static/app.js", "If-None-Match", etag)
+	if recorder.Code != http.StatusOK || recorder.Header().Get("ETag") == etag {
+		t.Fatalf("changed content got status %d ETag %q", recorder.Code, recorder.Header().Get("ETag"))
+	}
+}
+
+func TestAcceptGzip(t *testing.T) {
+	accept := func(acceptEncoding string) bool {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		return acceptGzip(req)
+	}
+
+	if !accept("br, gzip") || !accept("GZIP;q=0.5") || !accept("*") {
+		t.Fatal("gzip not accepted")
+	}
+	if accept("") || accept("br") || accept("gzip;q=0.0") || accept("gzip; q=0.000") || accept("gzip;q=0, *") {
+		t.Fatal("gzip accepted with q=0 or not listed")
+	}
+}
@@ -0,0 +1,324 @@
+package http_server
+
+import (
+	"context"
+	"fmt"
+	"github.com/RealJonathanYip/framework/context0"
+	"github.com/RealJonathanYip/framework/log"
+	"github.com/RealJonathanYip/framework/overflow"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DEFAULT_VERSION_HEADER = "X-Api-Version"
+
+const (
+	_VERSION_BY_PATH = iota
+	_VERSION_BY_HEADER
+	_VERSION_BY_MEDIA_TYPE
+)
+
+// application/vnd.company.v2+json
+var mediaTypeVersion = regexp.MustCompile(`\.v([0-9][0-9a-z.]*)\+`)
+
+type versionConf struct {
+	scheme      int
+	header      string
+	isDefault   bool
+	deprecation []deprecationOption
+	deprecated  bool
+}
+
+type versionOption interface {
+	apply(*versionConf)
+}
+
+type versionOptionFunc func(*versionConf)
+
+func (f versionOptionFunc) apply(conf *versionConf) {
+	f(conf)
+}
+
+// VersionByPath registers routes under /v{version}, it is the default
+func VersionByPath() versionOption {
+	return versionOptionFunc(func(conf *versionConf) {
+		conf.scheme = _VERSION_BY_PATH
+	})
+}
+
+// VersionByHeader selects the version by a request header, DEFAULT_VERSION_HEADER if name is empty,
+// the media type of Accept is also checked when the header is missing
+func VersionByHeader(name string) versionOption {
+	return versionOptionFunc(func(conf *versionConf) {
+		conf.scheme = _VERSION_BY_HEADER
+		if name != "" {
+			conf.header = name
+		}
+	})
+}
+
+// VersionByMediaType selects the version by Accept, e.g. application/vnd.app.v2+json or application/json;version=2
+func VersionByMediaType() versionOption {
+	return versionOptionFunc(func(conf *versionConf) {
+		conf.scheme = _VERSION_BY_MEDIA_TYPE
+	})
+}
+
+// VersionDefault serves this version to clients sending no version, only for header and media type versioning
+func VersionDefault() versionOption {
+	return versionOptionFunc(func(conf *versionConf) {
+		conf.isDefault = true
+	})
+}
+
+// VersionDeprecated marks every route of the version as deprecated
+func VersionDeprecated(opts ...deprecationOption) versionOption {
+	return versionOptionFunc(func(conf *versionConf) {
+		conf.deprecated = true
+		conf.deprecation = opts
+	})
+}
+
+// VersionGroup registers the routes of one api version, handlers can read it by Request.ApiVersion
+//
+// Example: server.Version("1", VersionDeprecated(DeprecationSunset(t))).Get("/users", listUsersV1, nil)
+type VersionGroup struct {
+	group   *RouteGroup
+	version string
+	conf    *versionConf
+}
+
+type versionDispatcher struct {
+	lock           sync.RWMutex
+	header         string
+	handlers       map[string]func(context.Context, *Response, *Request)
+	defaultHandler func(context.Context, *Response, *Request)
+	defaultVersion string
+}
+
+func (h *HttpServer) Version(version string, opts ...versionOption) *VersionGroup {
+	return (&RouteGroup{server: h}).Version(version, opts...)
+}
+
+func (g *RouteGroup) Version(version string, opts ...versionOption) *VersionGroup {
+	conf := &versionConf{scheme: _VERSION_BY_PATH, header: DEFAULT_VERSION_HEADER}
+	for _, opt := range opts {
+		opt.apply(conf)
+	}
+
+	return &VersionGroup{group: g, version: normalizeVersion(version), conf: conf}
+}
+
+func (v *VersionGroup) Post(szPath string, fnHandler, fnOnOverFlow func(context.Context, *Response, *Request), maxQPS ...uint32) {
+	v.Handle(_METHOD_POST, szPath, fnHandler, fnOnOverFlow, maxQPS...)
+}
+
+func (v *VersionGroup) Put(szPath string, fnHandler, fnOnOverFlow func(context.Context, *Response, *Request), maxQPS ...uint32) {
+	v.Handle(_METHOD_PUT, szPath, fnHandler, fnOnOverFlow, maxQPS...)
+}
+
+func (v *VersionGroup) Get(szPath string, fnHandler, fnOnOverFlow func(context.Context, *Response, *Request), maxQPS ...uint32) {
+	v.Handle(_METHOD_GET, szPath, fnHandler, fnOnOverFlow, maxQPS...)
+}
+
+func (v *VersionGroup) Delete(szPath string, fnHandler, fnOnOverFlow func(context.Context, *Response, *Request), maxQPS ...uint32) {
+	v.Handle(_METHOD_DELETE, szPath, fnHandler, fnOnOverFlow, maxQPS...)
+}
+
+// Handle registers the route of this version, for header and media type versioning every version of a path
+// shares one route whose overflow handler and QPS are taken from the first registration
+func (v *VersionGroup) Handle(szMethod, szPath string, fnHandler, fnOnOverFlow func(context.Context, *Response, *Request), maxQPS ...uint32) {
+	if v.conf.deprecated {
+		fnHandler = Deprecated(fnHandler, v.conf.deprecation...)
+	}
+
+	version := v.version
+	handler := func(ctx context.Context, resp *Response, req *Request) {
+		req.apiVersion = version
+		fnHandler(ctx, resp, req)
+	}
+
+	if v.conf.scheme == _VERSION_BY_PATH {
+		v.group.Handle(szMethod, "/v"+version+szPath, handler, fnOnOverFlow, maxQPS...)
+		return
+	}
+
+	server := v.group.server
+	key := versionKey(v.group.scope, szMethod, szPath)
+
+	server.versionLock.Lock()
+	defer server.versionLock.Unlock()
+
+	if server.versionDispatchers == nil {
+		server.versionDispatchers = make(map[string]*versionDispatcher)
+	}
+
+	dispatcher, exist := server.versionDispatchers[key]
+	if !exist {
+		dispatcher = &versionDispatcher{handlers: make(map[string]func(context.Context, *Response, *Request))}
+		v.group.Handle(szMethod, szPath, dispatcher.serve, fnOnOverFlow, maxQPS...)
+		server.versionDispatchers[key] = dispatcher
+	}
+
+	dispatcher.add(version, handler, v.conf)
+}
+
+func versionKey(scope routeScope, method, path string) string {
+	return scope.String() + method + "." + path
+}
+
+func (d *versionDispatcher) add(version string, handler func(context.Context, *Response, *Request), conf *versionConf) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if _, exist := d.handlers[version]; exist {
+		log.Panicf(context0.NewContext(), "api version:%s exist!", version)
+	}
+
+	d.handlers[version] = handler
+	if conf.scheme == _VERSION_BY_HEADER {
+		d.header = conf.header
+	}
+
+	if conf.isDefault {
+		d.defaultHandler = handler
+		d.defaultVersion = version
+	}
+}
+
+func (d *versionDispatcher) serve(ctx context.Context, resp *Response, req *Request) {
+	d.lock.RLock()
+	version := requestVersion(req.Request, d.header)
+	handler, exist := d.handlers[version]
+	if version == "" {
+		handler, exist = d.defaultHandler, d.defaultHandler != nil
+	}
+	header := d.header
+	d.lock.RUnlock()
+
+	// caches must not serve the reply of one version to another
+	if header != "" {
+		resp.Header().Add("Vary", header)
+	}
+	resp.Header().Add("Vary", "Accept")
+
+	if !exist {
+		log.Warningf(ctx, "api version:%s not found for %s", version, req.URL.Path)
+		http.Error(resp, fmt.Sprintf("api version %q not supported", version), http.StatusNotFound)
+		return
+	}
+
+	handler(ctx, resp, req)
+}
+
+func requestVersion(req *http.Request, header string) string {
+	if header != "" {
+		if version := req.Header.Get(header); version != "" {
+			return normalizeVersion(version)
+		}
+	}
+
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+
+		if version, exist := params["version"]; exist {
+			return normalizeVersion(version)
+		}
+
+		if match := mediaTypeVersion.FindStringSubmatch(mediaType); match != nil {
+			return normalizeVersion(match[1])
+		}
+	}
+
+	return ""
+}
+
+func normalizeVersion(version string) string {
+	return strings.TrimPrefix(strings.ToLower(strings.TrimSpace(version)), "v")
+}
+
+// ApiVersion is the version of the VersionGroup which registered the route, "" for unversioned routes
+func (r *Request) ApiVersion() string {
+	return r.apiVersion
+}
+
+type deprecationConf struct {
+	since  time.Time
+	sunset time.Time
+	link   string
+}
+
+type deprecationOption interface {
+	apply(*deprecationConf)
+}
+
+type deprecationOptionFunc func(*deprecationConf)
+
+func (f deprecationOptionFunc) apply(conf *deprecationConf) {
+	f(conf)
+}
+
+// DeprecationSince is sent as the Deprecation header, "true" is sent when it is not set
+func DeprecationSince(since time.Time) deprecationOption {
+	return deprecationOptionFunc(func(conf *deprecationConf) {
+		conf.since = since
+	})
+}
+
+// DeprecationSunset is sent as the Sunset header (RFC 8594)
+func DeprecationSunset(sunset time.Time) deprecationOption {
+	return deprecationOptionFunc(func(conf *deprecationConf) {
+		conf.sunset = sunset
+	})
+}
+
+// DeprecationLink points clients to the migration guide
+func DeprecationLink(url string) deprecationOption {
+	return deprecationOptionFunc(func(conf *deprecationConf) {
+		conf.link = url
+	})
+}
+
+// Deprecated wraps a route handler to send Deprecation/Sunset headers and log the callers still using it,
+// each caller is logged at most once per second for a route
+func Deprecated(handler func(context.Context, *Response, *Request), opts ...deprecationOption) func(context.Context, *Response, *Request) {
+	conf := &deprecationConf{}
+	for _, opt := range opts {
+		opt.apply(conf)
+	}
+
+	deprecation := "true"
+	if !conf.since.IsZero() {
+		deprecation = fmt.Sprintf("@%d", conf.since.Unix())
+	}
+
+	return func(ctx context.Context, resp *Response, req *Request) {
+		resp.Header().Set("Deprecation", deprecation)
+		if !conf.sunset.IsZero() {
+			resp.Header().Set("Sunset", conf.sunset.UTC().Format(http.TimeFormat))
+		}
+		if conf.link != "" {
+			resp.Header().Add("Link", fmt.Sprintf("<%s>; rel=\"deprecation\"", conf.link))
+		}
+
+		caller := req.ClientIP()
+		if upstream, exist := context0.Get(ctx, context0.ContextKeyUpstreamService); exist {
+			caller = upstream + "@" + caller
+		}
+
+		route := req.Method + "." + req.URL.Path
+		if bOverFlow, _, _ := overflow.Take("deprecated."+route+"."+caller, 1); !bOverFlow {
+			log.Warningf(ctx, "deprecated route:%s called by:%s user-agent:%s sunset:%v",
+				route, caller, req.UserAgent(), conf.sunset)
+		}
+
+		handler(ctx, resp, req)
+	}
+}
@@ -0,0 +1,133 @@
+package http_server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func replyVersion(ctx context.Context, resp *Response, req *Request) {
+	_, _ = resp.Write([]byte("v" + req.ApiVersion()))
+}
+
+func serveVersion(server *HttpServer, path string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestVersionByPath(t *testing.T) {
+	server := New("test")
+	server.Version("v1").Get("/users", replyVersion, nil)
+	server.Version("2").Get("/users", replyVersion, nil)
+
+	if got := serveVersion(server, "/v1/users").Body.String(); got != "v1" {
+		t.Errorf("/v1/users got %q", got)
+	}
+	if got := serveVersion(server, "/v2/users").Body.String(); got != "v2" {
+		t.Errorf("/v2/users got %q", got)
+	}
+	if recorder := serveVersion(server, "/users"); recorder.Code != http.StatusNotFound {
+		t.Errorf("unversioned path got status %d", recorder.Code)
+	}
+}
+
+func TestVersionByHeader(t *testing.T) {
+	server := New("test")
+	server.Version("1", VersionByHeader(""), VersionDefault()).Get("/users", replyVersion, nil)
+	server.Version("2", VersionByHeader("")).Get("/users", replyVersion, nil)
+
+	if got := serveVersion(server, "/users", DEFAULT_VERSION_HEADER, "V2").Body.String(); got != "v2" {
+		t.Errorf("version header got %q", got)
+	}
+	if got := serveVersion(server, "/users", "Accept", "application/vnd.app.v2+json").Body.String(); got != "v2" {
+		t.Errorf("media type without header got %q", got)
+	}
+	if got := serveVersion(server, "/users").Body.String(); got != "v1" {
+		t.Errorf("no version got %q, want the default", got)
+	}
+	if recorder := serveVersion(server, "/users", DEFAULT_VERSION_HEADER, "3"); recorder.Code != http.StatusNotFound {
+		t.Errorf("unknown version got status %d", recorder.Code)
+	}
+	if vary := serveVersion(server, "/users").Header().Values("Vary"); len(vary) != 2 ||
+		vary[0] != DEFAULT_VERSION_HEADER || vary[1] != "Accept" {
+		t.Errorf("got Vary %v", vary)
+	}
+}
+
+func TestVersionByMediaType(t *testing.T) {
+	server := New("test")
+	server.Version("1", VersionByMediaType()).Get("/users", replyVersion, nil)
+	server.Version("2", VersionByMediaType()).Get("/users", replyVersion, nil)
+
+	if got := serveVersion(server, "/users", "Accept", "text/html, application/vnd.app.v2+json").Body.String(); got != "v2" {
+		t.Errorf("vendor media type got %q", got)
+	}
+	if got := serveVersion(server, "/users", "Accept", "application/json; version=1").Body.String(); got != "v1" {
+		t.Errorf("version parameter got %q", got)
+	}
+	if recorder := serveVersion(server, "/users", "Accept", "application/json"); recorder.Code != http.StatusNotFound {
+		t.Errorf("no version and no default got status %d", recorder.Code)
+	}
+	if vary := serveVersion(server, "/users").Header().Values("Vary"); len(vary) != 1 || vary[0] != "Accept" {
+		t.Errorf("got Vary %v", vary)
+	}
+}
+
+func TestVersionRemove(t *testing.T) {
+	server := New("test")
+	server.Version("1", VersionByHeader("")).Get("/users", replyVersion, nil)
+
+	if !server.Remove(http.MethodGet, "/users") {
+		t.Fatal("versioned route not removed")
+	}
+	if recorder := serveVersion(server, "/users", DEFAULT_VERSION_HEADER, "1"); recorder.Code != http.StatusNotFound {
+		t.Fatalf("removed route got status %d", recorder.Code)
+	}
+
+	// registering the same version again must not panic and a new version must be served
+	server.Version("1", VersionByHeader("")).Get("/users", replyText("new v1"), nil)
+	server.Version("2", VersionByHeader("")).Get("/users", replyVersion, nil)
+	if got := serveVersion(server, "/users", DEFAULT_VERSION_HEADER, "1").Body.String(); got != "new v1" {
+		t.Errorf("re-registered version got %q", got)
+	}
+	if got := serveVersion(server, "/users", DEFAULT_VERSION_HEADER, "2").Body.String(); got != "v2" {
+		t.Errorf("new version got %q", got)
+	}
+}
+
+func TestVersionDeprecated(t *testing.T) {
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	sunset := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	server := New("test")
+	server.Version("1", VersionDeprecated(DeprecationSince(since), DeprecationSunset(sunset), DeprecationLink("https://example.com/v2"))).
+		Get("/users", replyVersion, nil)
+	server.Get("/legacy", Deprecated(replyText("legacy")), nil)
+
+	header := serveVersion(server, "/v1/users").Header()
+	if header.Get("Deprecation") != "@1704067200" || header.Get("Sunset") != "Wed, 01 Jan 2025 00:00:00 GMT" ||
+		header.Get("Link") != `<https://example.com/v2>; rel="deprecation"` {
+		t.Fatalf("got header %v", header)
+	}
+
+	server.Version("1", VersionByHeader(""), VersionDeprecated(DeprecationSunset(sunset))).Get("/orders", replyVersion, nil)
+	server.Version("2", VersionByHeader("")).Get("/orders", replyVersion, nil)
+	if header = serveVersion(server, "/orders", DEFAULT_VERSION_HEADER, "1").Header(); header.Get("Deprecation") != "true" ||
+		header.Get("Sunset") != "Wed, 01 Jan 2025 00:00:00 GMT" {
+		t.Fatalf("deprecated header version got header %v", header)
+	}
+	if header = serveVersion(server, "/orders", DEFAULT_VERSION_HEADER, "2").Header(); header.Get("Deprecation") != "" {
+		t.Fatalf("current version got header %v", header)
+	}
+
+	if header = serveVersion(server, "/legacy").Header(); header.Get("Deprecation") != "true" || header.Get("Sunset") != "" {
+		t.Fatalf("plain Deprecated got header %v", header)
+	}
+}
@@ -0,0 +1,214 @@
+package interceptor
+
+import (
+	"context"
+	"fmt"
+	context2 "github.com/RealJonathanYip/framework/context0"
+	"github.com/RealJonathanYip/framework/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"io"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	recv int64
+	sent int64
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *serverStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		atomic.AddInt64(&s.recv, 1)
+	}
+	return err
+}
+
+func (s *serverStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		atomic.AddInt64(&s.sent, 1)
+	}
+	return err
+}
+
+// WithServerStreamTraceInterceptor is the stream version of WithServerTraceInterceptor,
+// the stream is logged once when the handler returns
+func WithServerStreamTraceInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := context2.FromRpcContext(ss.Context())
+
+		upstreamService, exist := context2.Get(ctx, context2.ContextKeyUpstreamService)
+		if !exist {
+			upstreamService = "unknow"
+		}
+
+		upstreamMethod, exist := context2.Get(ctx, context2.ContextKeyUpstreamMethod)
+		if !exist {
+			upstreamMethod = "unknow"
+		}
+
+		methodInfos := strings.Split(info.FullMethod, "/")
+		method := info.FullMethod
+		service := processName
+		if len(methodInfos) == 3 {
+			method = methodInfos[2]
+			service = fmt.Sprintf("<grpc>-<%s>", methodInfos[1])
+		}
+		context2.Set(ctx, context2.ContextKeyCurrentMethod, method)
+		context2.Set(ctx, context2.ContextKeyCurrentService, service)
+
+		var upstreamAddress string
+		if peer, ok := peer.FromContext(ctx); ok {
+			if tcpAddr, ok := peer.Addr.(*net.TCPAddr); ok {
+				upstreamAddress = tcpAddr.String()
+			} else {
+				upstreamAddress = peer.Addr.String()
+			}
+		}
+		context2.Set(ctx, context2.ContextKeyUpstreamAddress, upstreamAddress)
+
+		log.Debugf(ctx, "【stream open】upstreamAddress:%s upstreamService:%v upstreamMethod:%v service:%v method:%v",
+			upstreamAddress, upstreamService, upstreamMethod, service, method)
+
+		stream := &serverStream{ServerStream: ss, ctx: ctx}
+		now := time.Now()
+		err := handler(srv, stream)
+		cost := time.Since(now).Milliseconds()
+
+		log.Infof(ctx, "【stream】upstreamAddress:%s upstreamService:%v upstreamMethod:%v service:%v method:%v cost:%v(ms) recv:%d sent:%d err:%v",
+			upstreamAddress, upstreamService, upstreamMethod, service, method, cost,
+			atomic.LoadInt64(&stream.recv), atomic.LoadInt64(&stream.sent), err)
+
+		return err
+	}
+}
+
+type clientStream struct {
+	grpc.ClientStream
+	recv    int64
+	sent    int64
+	once    sync.Once
+	onClose func(recv, sent int64, err error)
+}
+
+func (s *clientStream) SendMsg(m interface{}) error {
+	err := s.ClientStream.SendMsg(m)
+	if err == nil {
+		atomic.AddInt64(&s.sent, 1)
+	} else if err != io.EOF {
+		// io.EOF means the server closed the stream, its status is returned by RecvMsg
+		s.close(err)
+	}
+	return err
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err == nil {
+		atomic.AddInt64(&s.recv, 1)
+		return nil
+	}
+
+	if err == io.EOF {
+		s.close(nil)
+	} else {
+		s.close(err)
+	}
+	return err
+}
+
+func (s *clientStream) close(err error) {
+	s.once.Do(func() {
+		s.onClose(atomic.LoadInt64(&s.recv), atomic.LoadInt64(&s.sent), err)
+	})
+}
+
+// WithClientStreamInterceptor is the stream version of WithClientUnaryInterceptor,
+// the stream is logged once when RecvMsg returns an error or io.EOF, or when ctx is done
+// because the caller returned without reading it to the end
+func WithClientStreamInterceptor() grpc.DialOption {
+	return grpc.WithStreamInterceptor(func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		ctx = context2.Copy(ctx)
+
+		upstreamService, exist := context2.Get(ctx, context2.ContextKeyUpstreamService)
+		if !exist {
+			upstreamService = "unknow"
+		}
+		upstreamMethod, exist := context2.Get(ctx, context2.ContextKeyUpstreamMethod)
+		if !exist {
+			upstreamMethod = "unknow"
+		}
+
+		currentMethod, exist := context2.Get(ctx, context2.ContextKeyCurrentMethod)
+		if !exist {
+			pc := make([]uintptr, 1)
+			runtime.Callers(4, pc)
+			function := runtime.FuncForPC(pc[0])
+			currentMethod = fmt.Sprintf("<local>-<%s>", function.Name())
+		}
+		context2.Set(ctx, context2.ContextKeyUpstreamMethod, currentMethod)
+
+		currentService, exist := context2.Get(ctx, context2.ContextKeyCurrentService)
+		if !exist {
+			currentService = processName
+		}
+		context2.Set(ctx, context2.ContextKeyUpstreamService, currentService)
+
+		methodInfos := strings.Split(method, "/")
+		downstreamMethod := method
+		downstreamService := "unknow"
+		if len(methodInfos) == 3 {
+			downstreamMethod = methodInfos[2]
+			downstreamService = methodInfos[1]
+		}
+
+		context2.Del(ctx, context2.ContextKeyUpstreamAddress)
+
+		now := time.Now()
+		onClose := func(recv, sent int64, err error) {
+			cost := time.Since(now).Milliseconds()
+			log.Infof(ctx, "【stream request】upstreamService:%v upstreamMethod:%v downstreamService:%v downstreamMethod:%v currentService:%v currentMethod:%v cost:%v(ms) recv:%d sent:%d err:%v",
+				upstreamService, upstreamMethod, downstreamService, downstreamMethod, currentService, currentMethod, cost, recv, sent, err)
+		}
+
+		stream, err := streamer(context2.Prepare(ctx), desc, cc, method, opts...)
+		if err != nil {
+			onClose(0, 0, err)
+			return nil, err
+		}
+
+		traceStream := &clientStream{ClientStream: stream, onClose: onClose}
+		go func() {
+			select {
+			case <-ctx.Done():
+				traceStream.close(ctx.Err())
+			case <-stream.Context().Done():
+				// the stream context is also done when ctx is, otherwise RecvMsg or SendMsg returned the status
+				if err := ctx.Err(); err != nil {
+					traceStream.close(err)
+				}
+			}
+		}()
+
+		return traceStream, nil
+	})
+}
@@ -0,0 +1,145 @@
+package interceptor
+
+import (
+	"context"
+	"github.com/RealJonathanYip/framework/context0"
+	"github.com/RealJonathanYip/framework/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+type streamLogWatcher struct {
+	marker string
+	logs   chan string
+}
+
+func (w *streamLogWatcher) OnMessage(level, msg string) {
+	if !strings.Contains(msg, w.marker) {
+		return
+	}
+
+	// watchers can't be removed, the ones of finished tests must not block the logger
+	select {
+	case w.logs <- msg:
+	default:
+	}
+}
+
+func watchLogs(marker string) chan string {
+	watcher := &streamLogWatcher{marker: marker, logs: make(chan string, 1)}
+	log.AddWatcher(watcher)
+	return watcher.logs
+}
+
+func waitLog(t *testing.T, logs chan string) string {
+	select {
+	case msg := <-logs:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("stream not logged")
+		return ""
+	}
+}
+
+type streamService struct {
+	testpb.UnimplementedTestServiceServer
+}
+
+func (s *streamService) StreamingOutputCall(req *testpb.StreamingOutputCallRequest, stream testpb.TestService_StreamingOutputCallServer) error {
+	for _, param := range req.GetResponseParameters() {
+		select {
+		case <-time.After(time.Duration(param.GetIntervalUs()) * time.Microsecond):
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+		if err := stream.Send(&testpb.StreamingOutputCallResponse{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dialStreamServer serves streamService with the server stream interceptor and dials it with the client one
+func dialStreamServer(t *testing.T) *grpc.ClientConn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen err:%v", err)
+	}
+	server := grpc.NewServer(grpc.StreamInterceptor(WithServerStreamTraceInterceptor()))
+	testpb.RegisterTestServiceServer(server, &streamService{})
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()),
+		WithClientStreamInterceptor())
+	if err != nil {
+		t.Fatalf("dial err:%v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return conn
+}
+
+func TestStreamTrace(t *testing.T) {
+	conn := dialStreamServer(t)
+	serverLogs, clientLogs := watchLogs("【stream】"), watchLogs("【stream request】")
+
+	ctx := context0.NewContext()
+	traceID, _ := context0.Get(ctx, context0.ContextKeyTraceID)
+	req := &testpb.StreamingOutputCallRequest{ResponseParameters: []*testpb.ResponseParameters{{}, {}}}
+	stream, err := testpb.NewTestServiceClient(conn).StreamingOutputCall(ctx, req)
+	if err != nil {
+		t.Fatalf("call err:%v", err)
+	}
+	for {
+		if _, err := stream.Recv(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("recv err:%v", err)
+		}
+	}
+
+	serverLog := waitLog(t, serverLogs)
+	for _, want := range []string{"method:StreamingOutputCall", "recv:1 sent:2 err:<nil>", "traceID:" + traceID} {
+		if !strings.Contains(serverLog, want) {
+			t.Errorf("server log %q has no %q", serverLog, want)
+		}
+	}
+
+	clientLog := waitLog(t, clientLogs)
+	for _, want := range []string{"downstreamMethod:StreamingOutputCall", "recv:2 sent:1 err:<nil>", "traceID:" + traceID} {
+		if !strings.Contains(clientLog, want) {
+			t.Errorf("client log %q has no %q", clientLog, want)
+		}
+	}
+}
+
+func TestClientStreamLoggedWhenCallerReturns(t *testing.T) {
+	conn := dialStreamServer(t)
+	serverLogs, clientLogs := watchLogs("【stream】"), watchLogs("【stream request】")
+
+	// the second reply never comes in time, the caller reads the first one and returns
+	ctx, cancel := context.WithCancel(context0.NewContext())
+	req := &testpb.StreamingOutputCallRequest{ResponseParameters: []*testpb.ResponseParameters{{}, {IntervalUs: 60 * 1000 * 1000}}}
+	stream, err := testpb.NewTestServiceClient(conn).StreamingOutputCall(ctx, req)
+	if err != nil {
+		t.Fatalf("call err:%v", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("recv err:%v", err)
+	}
+	cancel()
+
+	if msg := waitLog(t, clientLogs); !strings.Contains(msg, "recv:1") || !strings.Contains(msg, "context canceled") {
+		t.Fatalf("got log %q", msg)
+	}
+	// the watchers are not synchronized with the logger, the server must be done logging
+	waitLog(t, serverLogs)
+}
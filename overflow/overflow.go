@@ -14,19 +14,31 @@ type OverFlowConfig struct {
 var (
 	_key2QpsLimit map[string]*OverFlowConfig
 	_rwLock       *sync.RWMutex
+	_lastSweep    int64
 )
 
+const _SWEEP_INTERVAL = 60
+
 func init() {
 	_key2QpsLimit = make(map[string]*OverFlowConfig)
 	_rwLock = new(sync.RWMutex)
+	_lastSweep = time.Now().Unix()
 }
 
 func IsOverFlow(szKey string, nQps uint32) bool {
+	bOverFlow, _, _ := Take(szKey, nQps)
+	return bOverFlow
+}
+
+// Take counts one request of szKey in the current second,
+// it also returns the quota left and the time until the counter resets
+func Take(szKey string, nQps uint32) (bool, uint32, time.Duration) {
 	_rwLock.RLock()
 	ptrConfig, bExist := _key2QpsLimit[szKey]
 	_rwLock.RUnlock()
 
 	objTime := time.Now()
+	resetIn := time.Unix(objTime.Unix()+1, 0).Sub(objTime)
 	if !bExist {
 		ptrConfig = new(OverFlowConfig)
 		ptrConfig.CurrentQps = 0
@@ -36,15 +48,39 @@ func IsOverFlow(szKey string, nQps uint32) bool {
 		_key2QpsLimit[szKey] = ptrConfig
 		_rwLock.Unlock()
 	}
+	sweep(objTime.Unix())
 
 	if atomic.LoadInt64(&ptrConfig.TimeStamp) != objTime.Unix() {
 		atomic.StoreInt64(&ptrConfig.TimeStamp, objTime.Unix())
 		atomic.StoreUint32(&ptrConfig.CurrentQps, 1)
-		return false
-	} else if atomic.LoadUint32(&ptrConfig.CurrentQps) >= nQps {
-		return true
+		return false, remain(nQps, 1), resetIn
+	} else if current := atomic.LoadUint32(&ptrConfig.CurrentQps); current >= nQps {
+		return true, 0, resetIn
 	} else {
-		atomic.AddUint32(&ptrConfig.CurrentQps, 1)
-		return false
+		return false, remain(nQps, atomic.AddUint32(&ptrConfig.CurrentQps, 1)), resetIn
+	}
+}
+
+func remain(nQps, nCurrent uint32) uint32 {
+	if nCurrent >= nQps {
+		return 0
+	}
+
+	return nQps - nCurrent
+}
+
+// sweep drops idle keys, e.g. per client ip keys, so the map does not grow forever
+func sweep(nNow int64) {
+	nLast := atomic.LoadInt64(&_lastSweep)
+	if nNow-nLast < _SWEEP_INTERVAL || !atomic.CompareAndSwapInt64(&_lastSweep, nLast, nNow) {
+		return
+	}
+
+	_rwLock.Lock()
+	defer _rwLock.Unlock()
+	for szKey, ptrConfig := range _key2QpsLimit {
+		if nNow-atomic.LoadInt64(&ptrConfig.TimeStamp) > _SWEEP_INTERVAL {
+			delete(_key2QpsLimit, szKey)
+		}
 	}
 }
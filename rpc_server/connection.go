@@ -0,0 +1,163 @@
+package rpc_server
+
+import (
+	"context"
+	"github.com/RealJonathanYip/framework/discovery"
+	"github.com/RealJonathanYip/framework/interceptor"
+	"github.com/RealJonathanYip/framework/log"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"strings"
+	"time"
+)
+
+type connectionConf struct {
+	dialTimeout      time.Duration
+	keepaliveTime    time.Duration
+	keepaliveTimeout time.Duration
+	maxMsgSize       int
+	healthCheck      bool
+}
+
+type connectionOption interface {
+	apply(*connectionConf)
+}
+
+type connectionOptionFunc func(*connectionConf)
+
+func (f connectionOptionFunc) apply(conf *connectionConf) {
+	f(conf)
+}
+
+// DialTimeout bounds every attempt to connect an instance, default 3s
+func DialTimeout(timeout time.Duration) connectionOption {
+	return connectionOptionFunc(func(conf *connectionConf) {
+		conf.dialTimeout = timeout
+	})
+}
+
+// DialKeepalive pings idle connections every interval and drops them without ack in timeout, default off,
+// pings are accepted by RpcServer every 10s at most
+func DialKeepalive(interval, timeout time.Duration) connectionOption {
+	return connectionOptionFunc(func(conf *connectionConf) {
+		conf.keepaliveTime = interval
+		conf.keepaliveTimeout = timeout
+	})
+}
+
+// DialMaxMsgSize limits messages sent and received, default 4MB of grpc
+func DialMaxMsgSize(size int) connectionOption {
+	return connectionOptionFunc(func(conf *connectionConf) {
+		conf.maxMsgSize = size
+	})
+}
+
+// DialHealthCheck routes rpcs only to instances whose grpc.health.v1 status is SERVING, default on
+func DialHealthCheck(enable bool) connectionOption {
+	return connectionOptionFunc(func(conf *connectionConf) {
+		conf.healthCheck = enable
+	})
+}
+
+// SetConnectionOptions configures the connections created by GetRpcServiceConnection afterwards
+func (r *RpcServer) SetConnectionOptions(opts ...connectionOption) {
+	r.connLock.Lock()
+	defer r.connLock.Unlock()
+
+	for _, opt := range opts {
+		opt.apply(&r.connConf)
+	}
+}
+
+// GetRpcServiceConnection returns the connection shared by all callers of serviceName, created on the first call,
+// it is balanced over all the instances found in the registry and must not be closed by callers.
+// "user" is looked up as "rpc.user", the balancing policy is chosen by discovery.SetBalancer
+func (r *RpcServer) GetRpcServiceConnection(serviceName string) (*grpc.ClientConn, error) {
+	if !strings.HasPrefix(serviceName, "rpc.") {
+		serviceName = "rpc." + serviceName
+	}
+
+	r.connLock.Lock()
+	defer r.connLock.Unlock()
+
+	if conn, exist := r.conns[serviceName]; exist {
+		switch conn.GetState() {
+		case connectivity.Shutdown:
+			// closed by a caller, dial again below
+			log.Warningf(context.TODO(), "connection of %s was closed", serviceName)
+		case connectivity.TransientFailure:
+			// try at once instead of waiting for the backoff
+			conn.ResetConnectBackoff()
+			return conn, nil
+		default:
+			return conn, nil
+		}
+	}
+
+	target := discovery.Target(serviceName)
+	conn, err := grpc.Dial(target, r.dialOptions()...)
+	if err != nil {
+		if conn != nil {
+			_ = conn.Close()
+		}
+
+		log.Errorf(context.TODO(), "connect to %s-%v fail: %v", serviceName, target, err)
+		return nil, err
+	}
+
+	if r.conns == nil {
+		r.conns = make(map[string]*grpc.ClientConn)
+	}
+	r.conns[serviceName] = conn
+
+	return conn, nil
+}
+
+func (r *RpcServer) dialOptions() []grpc.DialOption {
+	conf := r.connConf
+	options := []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithResolvers(discovery.NewResolverBuilder(r.registry, conf.healthCheck)),
+		grpc.WithConnectParams(grpc.ConnectParams{Backoff: backoff.DefaultConfig, MinConnectTimeout: conf.dialTimeout}),
+		interceptor.WithClientUnaryInterceptor(),
+		interceptor.WithClientStreamInterceptor(),
+	}
+
+	if conf.keepaliveTime > 0 {
+		options = append(options, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                conf.keepaliveTime,
+			Timeout:             conf.keepaliveTimeout,
+			PermitWithoutStream: true,
+		}))
+	}
+
+	if conf.maxMsgSize > 0 {
+		options = append(options, grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(conf.maxMsgSize), grpc.MaxCallSendMsgSize(conf.maxMsgSize)))
+	}
+
+	return options
+}
+
+// CloseConnections closes all the connections of GetRpcServiceConnection, Shutdown calls it after draining
+func (r *RpcServer) CloseConnections() error {
+	r.connLock.Lock()
+	conns := r.conns
+	r.conns = nil
+	r.connLock.Unlock()
+
+	var result error
+	for serviceName, conn := range conns {
+		if err := conn.Close(); err != nil {
+			log.Warningf(context.TODO(), "close connection of %s err:%v", serviceName, err)
+			if result == nil {
+				result = errors.Wrapf(err, "close connection of %s", serviceName)
+			}
+		}
+	}
+
+	return result
+}
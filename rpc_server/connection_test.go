@@ -0,0 +1,60 @@
+package rpc_server
+
+import (
+	"context"
+	"github.com/RealJonathanYip/framework/discovery"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"net"
+	"testing"
+)
+
+func TestGetRpcServiceConnection(t *testing.T) {
+	callee := New("callee")
+	testpb.RegisterTestServiceServer(callee, &testService{})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen err:%v", err)
+	}
+	go callee.server.Serve(listener)
+	defer callee.server.Stop()
+
+	registry := discovery.NewMemoryRegistry()
+	_ = registry.Register(context.Background(), discovery.Instance{Service: "rpc.callee", Address: listener.Addr().String()}, 0)
+
+	caller := New("caller")
+	caller.SetRegistry(registry)
+	call := func(conn *grpc.ClientConn) {
+		req := &testpb.SimpleRequest{ResponseStatus: &testpb.EchoStatus{Message: "alice"}}
+		resp, err := testpb.NewTestServiceClient(conn).UnaryCall(context.Background(), req, grpc.WaitForReady(true))
+		if err != nil || resp.GetUsername() != "alice" {
+			t.Fatalf("call got %v err:%v", resp, err)
+		}
+	}
+
+	conn, err := caller.GetRpcServiceConnection("callee")
+	if err != nil {
+		t.Fatalf("GetRpcServiceConnection err:%v", err)
+	}
+	call(conn)
+
+	if again, _ := caller.GetRpcServiceConnection("rpc.callee"); again != conn {
+		t.Fatal("the connection of a service is not shared")
+	}
+
+	// a caller closing the shared connection must not break the others
+	_ = conn.Close()
+	redialed, err := caller.GetRpcServiceConnection("callee")
+	if err != nil || redialed == conn {
+		t.Fatalf("closed connection not dialed again, err:%v", err)
+	}
+	call(redialed)
+
+	if err := caller.CloseConnections(); err != nil {
+		t.Fatalf("CloseConnections err:%v", err)
+	}
+	if state := redialed.GetState(); state != connectivity.Shutdown {
+		t.Fatalf("connection state %v after CloseConnections", state)
+	}
+}
@@ -0,0 +1,135 @@
+package rpc_server
+
+import (
+	"context"
+	"encoding/json"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// healthServer ends the Watch streams on Shutdown, they never end by themselves and GracefulStop waits for them
+type healthServer struct {
+	*health.Server
+	shutdown     chan struct{}
+	shutdownOnce sync.Once
+}
+
+func newHealthServer() *healthServer {
+	return &healthServer{Server: health.NewServer(), shutdown: make(chan struct{})}
+}
+
+// Shutdown sets every service NOT_SERVING and ends the Watch streams after telling them
+func (h *healthServer) Shutdown() {
+	h.Server.Shutdown()
+	h.shutdownOnce.Do(func() {
+		close(h.shutdown)
+	})
+}
+
+func (h *healthServer) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+
+	watchStream := &healthWatchStream{Health_WatchServer: stream, ctx: ctx}
+	go func() {
+		select {
+		case <-h.shutdown:
+			watchStream.end()
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return h.Server.Watch(req, watchStream)
+}
+
+type healthWatchStream struct {
+	healthpb.Health_WatchServer
+	ctx        context.Context
+	lock       sync.Mutex
+	ended      bool
+	lastStatus healthpb.HealthCheckResponse_ServingStatus
+}
+
+func (s *healthWatchStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *healthWatchStream) Send(resp *healthpb.HealthCheckResponse) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.ended {
+		return io.EOF
+	}
+
+	s.lastStatus = resp.Status
+	return s.Health_WatchServer.Send(resp)
+}
+
+// end sends NOT_SERVING as the last status, the status the watcher is waiting for may not be sent yet
+func (s *healthWatchStream) end() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.ended {
+		return
+	}
+
+	s.ended = true
+	if s.lastStatus != healthpb.HealthCheckResponse_NOT_SERVING {
+		_ = s.Health_WatchServer.Send(&healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_NOT_SERVING})
+	}
+}
+
+// SetServingStatus reports the status of a service for grpc.health.v1, "" is the whole server,
+// services are SERVING once registered, updates are ignored after Shutdown starts
+func (r *RpcServer) SetServingStatus(service string, status healthpb.HealthCheckResponse_ServingStatus) {
+	r.health.SetServingStatus(service, status)
+}
+
+// ServingStatus returns the status reported for service, SERVICE_UNKNOWN if it was never reported
+func (r *RpcServer) ServingStatus(service string) healthpb.HealthCheckResponse_ServingStatus {
+	resp, err := r.health.Check(context.TODO(), &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		return healthpb.HealthCheckResponse_SERVICE_UNKNOWN
+	}
+
+	return resp.Status
+}
+
+// Readiness is an http handler replying 200 when the server and services are all SERVING, 503 otherwise
+//
+// Example: httpServer.Get("/ready", http_server.WrapHandler(rpcServer.Readiness()), nil)
+func (r *RpcServer) Readiness(services ...string) http.HandlerFunc {
+	services = append([]string{""}, services...)
+
+	return func(resp http.ResponseWriter, req *http.Request) {
+		ready := true
+		statuses := make(map[string]string, len(services))
+		for _, service := range services {
+			status := r.ServingStatus(service)
+			if status != healthpb.HealthCheckResponse_SERVING {
+				ready = false
+			}
+
+			name := service
+			if name == "" {
+				name = r.name
+			}
+			statuses[name] = status.String()
+		}
+
+		code := http.StatusOK
+		if !ready {
+			code = http.StatusServiceUnavailable
+		}
+
+		resp.Header().Set("Content-Type", "application/json; charset=utf-8")
+		resp.WriteHeader(code)
+		_ = json.NewEncoder(resp).Encode(statuses)
+	}
+}
@@ -0,0 +1,88 @@
+package rpc_server
+
+import (
+	"context"
+	"encoding/json"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadiness(t *testing.T) {
+	server := New("test")
+	testpb.RegisterTestServiceServer(server, &testService{})
+	readiness := server.Readiness("grpc.testing.TestService")
+
+	ready := func() (int, map[string]string) {
+		recorder := httptest.NewRecorder()
+		readiness.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/ready", nil))
+
+		statuses := make(map[string]string)
+		if err := json.Unmarshal(recorder.Body.Bytes(), &statuses); err != nil {
+			t.Fatalf("got body %q err:%v", recorder.Body.String(), err)
+		}
+		return recorder.Code, statuses
+	}
+
+	if code, statuses := ready(); code != http.StatusOK || statuses["rpc.test"] != "SERVING" || statuses["grpc.testing.TestService"] != "SERVING" {
+		t.Fatalf("registered services got %d %v", code, statuses)
+	}
+
+	server.SetServingStatus("grpc.testing.TestService", healthpb.HealthCheckResponse_NOT_SERVING)
+	if code, statuses := ready(); code != http.StatusServiceUnavailable || statuses["grpc.testing.TestService"] != "NOT_SERVING" {
+		t.Fatalf("service not serving got %d %v", code, statuses)
+	}
+	server.SetServingStatus("grpc.testing.TestService", healthpb.HealthCheckResponse_SERVING)
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown err:%v", err)
+	}
+	if code, statuses := ready(); code != http.StatusServiceUnavailable || statuses["rpc.test"] != "NOT_SERVING" {
+		t.Fatalf("after Shutdown got %d %v", code, statuses)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	server := New("test")
+	testpb.RegisterTestServiceServer(server, &testService{})
+	client := healthpb.NewHealthClient(serveLocal(t, server))
+
+	resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "grpc.testing.TestService"})
+	if err != nil || resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("registered service got %v err:%v", resp, err)
+	}
+
+	if status := server.ServingStatus("grpc.testing.Missing"); status != healthpb.HealthCheckResponse_SERVICE_UNKNOWN {
+		t.Fatalf("unknown service got %v", status)
+	}
+}
+
+func TestShutdownEndsHealthWatch(t *testing.T) {
+	server := New("test")
+	client := healthpb.NewHealthClient(serveLocal(t, server))
+
+	stream, err := client.Watch(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("watch err:%v", err)
+	}
+	if resp, err := stream.Recv(); err != nil || resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("first status %v err:%v", resp, err)
+	}
+
+	// GracefulStop waits for the watch, it would hit the deadline if the watch did not end
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown err:%v", err)
+	}
+
+	if resp, err := stream.Recv(); err != nil || resp.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("last status %v err:%v", resp, err)
+	}
+	if _, err := stream.Recv(); err == nil {
+		t.Fatal("watch not ended by Shutdown")
+	}
+}
@@ -0,0 +1,88 @@
+package rpc_server
+
+import (
+	"context"
+	"github.com/RealJonathanYip/framework"
+	"google.golang.org/grpc"
+)
+
+type serverConf struct {
+	unaryBeforeTrace  []grpc.UnaryServerInterceptor
+	unaryAfterTrace   []grpc.UnaryServerInterceptor
+	streamBeforeTrace []grpc.StreamServerInterceptor
+	streamAfterTrace  []grpc.StreamServerInterceptor
+	serverOptions     []grpc.ServerOption
+	reflection        *bool
+	channelz          *bool
+	qpsLimits         map[string]qpsLimit
+	recoveryHandler   func(ctx context.Context, fullMethod string, p interface{}) error
+}
+
+type serverOption interface {
+	apply(*serverConf)
+}
+
+type serverOptionFunc func(*serverConf)
+
+func (f serverOptionFunc) apply(conf *serverConf) {
+	f(conf)
+}
+
+// UnaryInterceptor adds interceptors running inside the trace interceptor in the given order,
+// they see the context0 trace and are logged within the cost of 【serve】, e.g. auth and validation
+func UnaryInterceptor(interceptors ...grpc.UnaryServerInterceptor) serverOption {
+	return serverOptionFunc(func(conf *serverConf) {
+		conf.unaryAfterTrace = append(conf.unaryAfterTrace, interceptors...)
+	})
+}
+
+// UnaryInterceptorBeforeTrace adds interceptors running outside the trace interceptor, e.g. metrics of the whole rpc
+func UnaryInterceptorBeforeTrace(interceptors ...grpc.UnaryServerInterceptor) serverOption {
+	return serverOptionFunc(func(conf *serverConf) {
+		conf.unaryBeforeTrace = append(conf.unaryBeforeTrace, interceptors...)
+	})
+}
+
+// StreamInterceptor is UnaryInterceptor for streaming rpcs
+func StreamInterceptor(interceptors ...grpc.StreamServerInterceptor) serverOption {
+	return serverOptionFunc(func(conf *serverConf) {
+		conf.streamAfterTrace = append(conf.streamAfterTrace, interceptors...)
+	})
+}
+
+// StreamInterceptorBeforeTrace is UnaryInterceptorBeforeTrace for streaming rpcs
+func StreamInterceptorBeforeTrace(interceptors ...grpc.StreamServerInterceptor) serverOption {
+	return serverOptionFunc(func(conf *serverConf) {
+		conf.streamBeforeTrace = append(conf.streamBeforeTrace, interceptors...)
+	})
+}
+
+// ServerOptions passes options to grpc.NewServer, interceptors must be added by the options above
+// because grpc.UnaryInterceptor and grpc.StreamInterceptor can be set only once
+func ServerOptions(opts ...grpc.ServerOption) serverOption {
+	return serverOptionFunc(func(conf *serverConf) {
+		conf.serverOptions = append(conf.serverOptions, opts...)
+	})
+}
+
+// Reflection registers the grpc reflection service used by grpcurl, default on only when framework.IsTestEnv()
+func Reflection(enable bool) serverOption {
+	return serverOptionFunc(func(conf *serverConf) {
+		conf.reflection = &enable
+	})
+}
+
+// Channelz registers the grpc channelz service, default on only when framework.IsTestEnv()
+func Channelz(enable bool) serverOption {
+	return serverOptionFunc(func(conf *serverConf) {
+		conf.channelz = &enable
+	})
+}
+
+func (c *serverConf) enabled(option *bool) bool {
+	if option != nil {
+		return *option
+	}
+
+	return framework.IsTestEnv()
+}
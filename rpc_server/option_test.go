@@ -0,0 +1,105 @@
+package rpc_server
+
+import (
+	"context"
+	context0 "github.com/RealJonathanYip/framework/context0"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/status"
+	"strings"
+	"testing"
+)
+
+// interceptorTrace records the interceptors in call order with the method context0 knows at that point
+type interceptorTrace []string
+
+func (trace *interceptorTrace) add(name string, ctx context.Context) {
+	method, _ := context0.Get(ctx, context0.ContextKeyCurrentMethod)
+	*trace = append(*trace, name+":"+method)
+}
+
+func (trace *interceptorTrace) unary(name string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		trace.add(name, ctx)
+		return handler(ctx, req)
+	}
+}
+
+func (trace *interceptorTrace) stream(name string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		trace.add(name, ss.Context())
+		return handler(srv, ss)
+	}
+}
+
+func TestUnaryInterceptorOrder(t *testing.T) {
+	trace := &interceptorTrace{}
+	server := New("test",
+		UnaryInterceptor(trace.unary("auth"), trace.unary("validate")),
+		UnaryInterceptorBeforeTrace(trace.unary("metrics")),
+	)
+	testpb.RegisterTestServiceServer(server, &testService{})
+
+	_, err := server.Invoke(context0.NewContext(), "/grpc.testing.TestService/UnaryCall", func(interface{}) error { return nil })
+	if err != nil {
+		t.Fatalf("Invoke err:%v", err)
+	}
+
+	if got := strings.Join(*trace, ","); got != "metrics:,auth:UnaryCall,validate:UnaryCall" {
+		t.Fatalf("interceptors ran as %s", got)
+	}
+}
+
+func TestStreamInterceptorOrder(t *testing.T) {
+	trace := &interceptorTrace{}
+	server := New("test",
+		StreamInterceptorBeforeTrace(trace.stream("metrics")),
+		StreamInterceptor(trace.stream("auth")),
+	)
+	testpb.RegisterTestServiceServer(server, &testService{})
+	conn := serveLocal(t, server)
+
+	stream, err := testpb.NewTestServiceClient(conn).StreamingOutputCall(context.Background(), &testpb.StreamingOutputCallRequest{})
+	if err != nil {
+		t.Fatalf("call err:%v", err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.Unimplemented {
+		t.Fatalf("recv err:%v", err)
+	}
+
+	if got := strings.Join(*trace, ","); got != "metrics:,auth:StreamingOutputCall" {
+		t.Fatalf("interceptors ran as %s", got)
+	}
+}
+
+func TestServerOptions(t *testing.T) {
+	server := New("test", ServerOptions(grpc.MaxRecvMsgSize(16)))
+	testpb.RegisterTestServiceServer(server, &testService{})
+	conn := serveLocal(t, server)
+
+	req := &testpb.SimpleRequest{ResponseStatus: &testpb.EchoStatus{Message: strings.Repeat("a", 32)}}
+	if _, err := testpb.NewTestServiceClient(conn).UnaryCall(context.Background(), req); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("message over the limit got err:%v", err)
+	}
+}
+
+func TestDebugServices(t *testing.T) {
+	registered := func(server *RpcServer) (reflection, channelz bool) {
+		services := server.server.GetServiceInfo()
+		_, reflection = services["grpc.reflection.v1alpha.ServerReflection"]
+		_, channelz = services["grpc.channelz.v1.Channelz"]
+		return
+	}
+
+	// the tests run without a config file, which is not a test env
+	if reflection, channelz := registered(New("test")); reflection || channelz {
+		t.Fatalf("default got reflection:%v channelz:%v outside test env", reflection, channelz)
+	}
+	if reflection, channelz := registered(New("test", Reflection(true), Channelz(true))); !reflection || !channelz {
+		t.Fatalf("enabled got reflection:%v channelz:%v", reflection, channelz)
+	}
+	if reflection, channelz := registered(New("test", Reflection(true))); !reflection || channelz {
+		t.Fatalf("reflection only got reflection:%v channelz:%v", reflection, channelz)
+	}
+}
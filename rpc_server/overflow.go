@@ -0,0 +1,122 @@
+package rpc_server
+
+import (
+	"context"
+	"github.com/RealJonathanYip/framework/context0"
+	"github.com/RealJonathanYip/framework/log"
+	"github.com/RealJonathanYip/framework/overflow"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"math"
+	"time"
+)
+
+// QPS_UNLIMITED exempts a method from the limit of "", 0 inherits it
+const QPS_UNLIMITED = math.MaxUint32
+
+type qpsLimit struct {
+	method   uint32 // 0 inherits the limit of "", QPS_UNLIMITED or 0 in "" for no limit
+	upstream uint32
+}
+
+// MethodQPS limits the rpcs of fullMethod per second, "" for every method without its own limit, default no limit
+func MethodQPS(fullMethod string, qps uint32) serverOption {
+	return serverOptionFunc(func(conf *serverConf) {
+		limit := conf.qpsLimits[fullMethod]
+		limit.method = qps
+		conf.qpsLimits[fullMethod] = limit
+	})
+}
+
+// UpstreamQPS limits the rpcs of fullMethod per second from each upstream service, "" for every method
+// without its own limit, default no limit.
+// The upstream service is the one sent in the metadata by the caller, so this shares the capacity between
+// trusted services only, it does not stop a client lying about its name
+func UpstreamQPS(fullMethod string, qps uint32) serverOption {
+	return serverOptionFunc(func(conf *serverConf) {
+		limit := conf.qpsLimits[fullMethod]
+		limit.upstream = qps
+		conf.qpsLimits[fullMethod] = limit
+	})
+}
+
+// qpsLimit merges the limits of fullMethod with the ones of "", the map is read only after New
+func (c *serverConf) qpsLimit(fullMethod string) qpsLimit {
+	limit, defaultLimit := c.qpsLimits[fullMethod], c.qpsLimits[""]
+	if limit.method == 0 {
+		limit.method = defaultLimit.method
+	}
+	if limit.upstream == 0 {
+		limit.upstream = defaultLimit.upstream
+	}
+
+	return limit
+}
+
+// takeQPS returns a ResourceExhausted error with RetryInfo when fullMethod is over its limits
+func (r *RpcServer) takeQPS(ctx context.Context, fullMethod string) error {
+	limit := r.conf.qpsLimit(fullMethod)
+	key := r.name + "." + fullMethod
+
+	if limited(limit.method) {
+		if bOverFlow, _, resetIn := overflow.Take(key, limit.method); bOverFlow {
+			log.Warningf(ctx, "rpc method:%s over flow qps:%d", fullMethod, limit.method)
+			return overFlowError(fullMethod, resetIn)
+		}
+	}
+
+	if !limited(limit.upstream) {
+		return nil
+	}
+
+	upstreamService, exist := context0.Get(ctx, context0.ContextKeyUpstreamService)
+	if !exist {
+		upstreamService = "unknow"
+	}
+
+	if bOverFlow, _, resetIn := overflow.Take(key+"."+upstreamService, limit.upstream); bOverFlow {
+		log.Warningf(ctx, "rpc method:%s upstream:%s over flow qps:%d", fullMethod, upstreamService, limit.upstream)
+		return overFlowError(fullMethod, resetIn)
+	}
+
+	return nil
+}
+
+func limited(qps uint32) bool {
+	return qps != 0 && qps != QPS_UNLIMITED
+}
+
+func overFlowError(fullMethod string, resetIn time.Duration) error {
+	statusTemp := status.Newf(codes.ResourceExhausted, "method %s over flow! plase try again later", fullMethod)
+	if withDetails, err := statusTemp.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(resetIn)}); err == nil {
+		statusTemp = withDetails
+	}
+
+	return statusTemp.Err()
+}
+
+// WithServerOverFlowInterceptor applies MethodQPS and UpstreamQPS, it runs inside the trace interceptor
+// to know the upstream service
+func (r *RpcServer) WithServerOverFlowInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := r.takeQPS(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// WithServerStreamOverFlowInterceptor counts the streams opened against MethodQPS and UpstreamQPS
+func (r *RpcServer) WithServerStreamOverFlowInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := r.takeQPS(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+
+		return handler(srv, ss)
+	}
+}
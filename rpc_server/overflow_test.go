@@ -0,0 +1,110 @@
+package rpc_server
+
+import (
+	"context"
+	"fmt"
+	context0 "github.com/RealJonathanYip/framework/context0"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+const _UNARY_CALL = "/grpc.testing.TestService/UnaryCall"
+
+// waitSecondStart keeps the rpcs of a test inside one overflow counting second
+func waitSecondStart() {
+	if elapsed := time.Duration(time.Now().Nanosecond()); elapsed > 500*time.Millisecond {
+		time.Sleep(time.Second - elapsed)
+	}
+}
+
+// uniqueName keeps the overflow counters of repeated test runs apart
+func uniqueName(name string) string {
+	return fmt.Sprintf("%s_%d", name, time.Now().UnixNano())
+}
+
+// invokeFrom calls fullMethod in-process as the upstream service
+func invokeFrom(server *RpcServer, upstream, fullMethod string) error {
+	ctx := context0.NewContext()
+	context0.Set(ctx, context0.ContextKeyCurrentService, upstream)
+	_, err := server.Invoke(ctx, fullMethod, func(interface{}) error { return nil })
+	return err
+}
+
+func TestMethodQPS(t *testing.T) {
+	server := New(uniqueName("method_qps"), MethodQPS(_UNARY_CALL, 2))
+	testpb.RegisterTestServiceServer(server, &testService{})
+
+	waitSecondStart()
+	for i := 0; i < 2; i++ {
+		if err := invokeFrom(server, "a", _UNARY_CALL); err != nil {
+			t.Fatalf("call %d err:%v", i, err)
+		}
+	}
+
+	err := invokeFrom(server, "b", _UNARY_CALL)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("call over the limit err:%v", err)
+	}
+	details := status.Convert(err).Details()
+	if len(details) != 1 {
+		t.Fatalf("got details %v", details)
+	}
+	if retryInfo, ok := details[0].(*errdetails.RetryInfo); !ok || retryInfo.GetRetryDelay().AsDuration() > time.Second {
+		t.Fatalf("got detail %v, want RetryInfo within a second", details[0])
+	}
+
+	if err := invokeFrom(server, "a", "/grpc.testing.TestService/EmptyCall"); status.Code(err) != codes.Unimplemented {
+		t.Fatalf("other method err:%v, want no limit", err)
+	}
+}
+
+func TestUpstreamQPS(t *testing.T) {
+	server := New(uniqueName("upstream_qps"), UpstreamQPS("", 1))
+	testpb.RegisterTestServiceServer(server, &testService{})
+
+	waitSecondStart()
+	if err := invokeFrom(server, "a", _UNARY_CALL); err != nil {
+		t.Fatalf("first call err:%v", err)
+	}
+	if err := invokeFrom(server, "a", _UNARY_CALL); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("second call of a err:%v", err)
+	}
+	if err := invokeFrom(server, "b", _UNARY_CALL); err != nil {
+		t.Fatalf("first call of b err:%v", err)
+	}
+}
+
+// countLimited returns how many of calls takeQPS rejects
+func countLimited(t *testing.T, server *RpcServer, fullMethod string, calls int) int {
+	limited := 0
+	for i := 0; i < calls; i++ {
+		if err := server.takeQPS(context.Background(), fullMethod); err != nil {
+			if status.Code(err) != codes.ResourceExhausted {
+				t.Fatalf("got err:%v, want ResourceExhausted", err)
+			}
+			limited++
+		}
+	}
+
+	return limited
+}
+
+func TestQPSDefaultLimit(t *testing.T) {
+	waitSecondStart()
+	if limited := countLimited(t, New(uniqueName("no_default")), _UNARY_CALL, 20000); limited != 0 {
+		t.Fatalf("no limit configured rejected %d calls", limited)
+	}
+
+	server := New(uniqueName("default"), MethodQPS("", 2), MethodQPS(_UNARY_CALL, QPS_UNLIMITED),
+		UpstreamQPS("/grpc.testing.TestService/EmptyCall", 100))
+	if limited := countLimited(t, server, _UNARY_CALL, 10); limited != 0 {
+		t.Fatalf("exempted method rejected %d calls", limited)
+	}
+	if limited := countLimited(t, server, "/grpc.testing.TestService/EmptyCall", 10); limited != 8 {
+		t.Fatalf("method without its own qps rejected %d of 10 calls, want the limit of \"\"", limited)
+	}
+}
@@ -0,0 +1,55 @@
+package rpc_server
+
+import (
+	"context"
+	"github.com/RealJonathanYip/framework/log"
+	"github.com/RealJonathanYip/framework/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RecoveryHandler turns a panic of a handler into the error returned to the client, after the stack is logged,
+// codes.Internal without the panic value by default
+func RecoveryHandler(handler func(ctx context.Context, fullMethod string, p interface{}) error) serverOption {
+	return serverOptionFunc(func(conf *serverConf) {
+		conf.recoveryHandler = handler
+	})
+}
+
+func (r *RpcServer) recover(ctx context.Context, fullMethod string, p interface{}) error {
+	// skip recover and the deferred func
+	log.Errorf(ctx, "rpc method:%s panic recovered:\n%v\n%s", fullMethod, p, utils.Stack(2))
+
+	if r.conf.recoveryHandler != nil {
+		return r.conf.recoveryHandler(ctx, fullMethod, p)
+	}
+
+	return status.Errorf(codes.Internal, "method %s internal error", fullMethod)
+}
+
+// WithServerRecoveryInterceptor recovers panics of the handler and the interceptors inside it,
+// New puts one first in the chain and another inside the trace interceptor so the stack is logged with the trace ID
+func (r *RpcServer) WithServerRecoveryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				resp, err = nil, r.recover(ctx, info.FullMethod, p)
+			}
+		}()
+
+		return handler(ctx, req)
+	}
+}
+
+func (r *RpcServer) WithServerStreamRecoveryInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = r.recover(ss.Context(), info.FullMethod, p)
+			}
+		}()
+
+		return handler(srv, ss)
+	}
+}
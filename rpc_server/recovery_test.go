@@ -0,0 +1,106 @@
+package rpc_server
+
+import (
+	"context"
+	"github.com/RealJonathanYip/framework/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/status"
+	"strings"
+	"testing"
+)
+
+type panicService struct {
+	testpb.UnimplementedTestServiceServer
+}
+
+func (s *panicService) UnaryCall(ctx context.Context, req *testpb.SimpleRequest) (*testpb.SimpleResponse, error) {
+	panic("secret state")
+}
+
+func (s *panicService) StreamingOutputCall(req *testpb.StreamingOutputCallRequest, stream testpb.TestService_StreamingOutputCallServer) error {
+	panic("secret state")
+}
+
+type panicLogWatcher struct {
+	logs chan string
+}
+
+func (w *panicLogWatcher) OnMessage(level, msg string) {
+	if !strings.Contains(msg, "panic recovered") {
+		return
+	}
+
+	select {
+	case w.logs <- msg:
+	default:
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	server := New("test")
+	testpb.RegisterTestServiceServer(server, &panicService{})
+	client := testpb.NewTestServiceClient(serveLocal(t, server))
+
+	watcher := &panicLogWatcher{logs: make(chan string, 1)}
+	log.AddWatcher(watcher)
+
+	_, err := client.UnaryCall(context.Background(), &testpb.SimpleRequest{})
+	if status.Code(err) != codes.Internal || strings.Contains(err.Error(), "secret") {
+		t.Fatalf("unary err:%v, want Internal without the panic value", err)
+	}
+	if msg := <-watcher.logs; !strings.Contains(msg, "secret state") || !strings.Contains(msg, "recovery_test.go") {
+		t.Fatalf("got log %q, want the panic value and stack", msg)
+	}
+
+	stream, err := client.StreamingOutputCall(context.Background(), &testpb.StreamingOutputCallRequest{})
+	if err != nil {
+		t.Fatalf("stream err:%v", err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.Internal {
+		t.Fatalf("stream recv err:%v", err)
+	}
+}
+
+func TestRecoveryHandler(t *testing.T) {
+	server := New("test",
+		UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			panic("interceptor panic")
+		}),
+		RecoveryHandler(func(ctx context.Context, fullMethod string, p interface{}) error {
+			return status.Errorf(codes.Unavailable, "%s: %v", fullMethod, p)
+		}),
+	)
+	testpb.RegisterTestServiceServer(server, &testService{})
+
+	_, err := server.Invoke(context.Background(), _UNARY_CALL, func(interface{}) error { return nil })
+	if status.Code(err) != codes.Unavailable || status.Convert(err).Message() != _UNARY_CALL+": interceptor panic" {
+		t.Fatalf("got err:%v", err)
+	}
+}
+
+func TestRecoveryBeforeTrace(t *testing.T) {
+	server := New("test",
+		UnaryInterceptorBeforeTrace(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			panic("before trace panic")
+		}),
+		StreamInterceptorBeforeTrace(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			panic("before trace panic")
+		}),
+	)
+	testpb.RegisterTestServiceServer(server, &testService{})
+	client := testpb.NewTestServiceClient(serveLocal(t, server))
+
+	if _, err := client.UnaryCall(context.Background(), &testpb.SimpleRequest{}); status.Code(err) != codes.Internal {
+		t.Fatalf("unary err:%v, want Internal", err)
+	}
+
+	stream, err := client.StreamingOutputCall(context.Background(), &testpb.StreamingOutputCallRequest{})
+	if err != nil {
+		t.Fatalf("stream err:%v", err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.Internal {
+		t.Fatalf("stream recv err:%v, want Internal", err)
+	}
+}
@@ -4,35 +4,207 @@ import (
 	"context"
 	"fmt"
 	context0 "github.com/RealJonathanYip/framework/context0"
+	"github.com/RealJonathanYip/framework/discovery"
 	"github.com/RealJonathanYip/framework/interceptor"
 	"github.com/RealJonathanYip/framework/log"
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/pkg/errors"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	channelz "google.golang.org/grpc/channelz/service"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 	"net"
 	"strings"
+	"sync"
 	"time"
 )
 
 type RpcServer struct {
-	listener net.Listener
-	port     uint16
-	server   *grpc.Server
-	name     string
+	listener          net.Listener
+	port              uint16
+	server            *grpc.Server
+	name              string
+	unaryInterceptor  grpc.UnaryServerInterceptor
+	streamInterceptor grpc.StreamServerInterceptor
+	services          map[string]*serviceInfo
+	onBeforeDrain     []func(context.Context)
+	onAfterDrain      []func(context.Context)
+	registry          discovery.Registry
+	heartbeat         *discovery.Heartbeat
+	lock              sync.Mutex
+	conns             map[string]*grpc.ClientConn
+	connConf          connectionConf
+	connLock          sync.Mutex
+	health            *healthServer
+	conf              *serverConf
 }
 
-func New(name string) *RpcServer {
-	rpcServer := &RpcServer{name: "rpc." + name}
-	rpcServer.server = grpc.NewServer(grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(
-		rpcServer.WithServerTraceInterceptor(),
-	)))
+type serviceInfo struct {
+	desc *grpc.ServiceDesc
+	impl interface{}
+}
+
+// New creates the server with the trace interceptors, the interceptors in opts are chained around or inside them
+//
+// Example: New("user", UnaryInterceptor(auth, validate), ServerOptions(grpc.MaxRecvMsgSize(8<<20)))
+func New(name string, opts ...serverOption) *RpcServer {
+	conf := &serverConf{qpsLimits: make(map[string]qpsLimit)}
+	for _, opt := range opts {
+		opt.apply(conf)
+	}
+
+	rpcServer := &RpcServer{
+		conf:     conf,
+		name:     "rpc." + name,
+		services: make(map[string]*serviceInfo),
+		connConf: connectionConf{dialTimeout: 3 * time.Second, healthCheck: true},
+	}
+
+	// the outer recovery covers the interceptors before trace, the inner one logs with the trace ID
+	unaryInterceptors := append([]grpc.UnaryServerInterceptor{rpcServer.WithServerRecoveryInterceptor()}, conf.unaryBeforeTrace...)
+	unaryInterceptors = append(unaryInterceptors, rpcServer.WithServerTraceInterceptor(),
+		rpcServer.WithServerRecoveryInterceptor(), rpcServer.WithServerOverFlowInterceptor())
+	unaryInterceptors = append(unaryInterceptors, conf.unaryAfterTrace...)
+	rpcServer.unaryInterceptor = grpc_middleware.ChainUnaryServer(unaryInterceptors...)
+
+	streamInterceptors := append([]grpc.StreamServerInterceptor{rpcServer.WithServerStreamRecoveryInterceptor()}, conf.streamBeforeTrace...)
+	streamInterceptors = append(streamInterceptors, interceptor.WithServerStreamTraceInterceptor(),
+		rpcServer.WithServerStreamRecoveryInterceptor(), rpcServer.WithServerStreamOverFlowInterceptor())
+	streamInterceptors = append(streamInterceptors, conf.streamAfterTrace...)
+	rpcServer.streamInterceptor = grpc_middleware.ChainStreamServer(streamInterceptors...)
+
+	serverOptions := []grpc.ServerOption{
+		grpc.UnaryInterceptor(rpcServer.unaryInterceptor),
+		grpc.StreamInterceptor(rpcServer.streamInterceptor),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{MinTime: 10 * time.Second, PermitWithoutStream: true}),
+	}
+	rpcServer.server = grpc.NewServer(append(serverOptions, conf.serverOptions...)...)
+
+	rpcServer.health = newHealthServer()
+	healthpb.RegisterHealthServer(rpcServer, rpcServer.health)
+
+	if conf.enabled(conf.reflection) {
+		reflection.Register(rpcServer.server)
+	}
+	if conf.enabled(conf.channelz) {
+		channelz.RegisterChannelzServiceToServer(rpcServer.server)
+	}
 
 	return rpcServer
 }
 
-func (r *RpcServer) Serve() {
-	//TODO: add service discover logic...
+// RegisterService implements grpc.ServiceRegistrar, it must be called before Serve
+func (r *RpcServer) RegisterService(desc *grpc.ServiceDesc, impl interface{}) {
+	r.server.RegisterService(desc, impl)
+	r.services[desc.ServiceName] = &serviceInfo{desc: desc, impl: impl}
+	if r.health != nil {
+		r.health.SetServingStatus(desc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	}
+}
+
+// Invoke calls a registered unary method in-process through the server interceptor chain,
+// dec fills the request message like the grpc codec does, ctx is propagated like an outgoing rpc
+func (r *RpcServer) Invoke(ctx context.Context, fullMethod string, dec func(interface{}) error) (interface{}, error) {
+	methodInfos := strings.Split(fullMethod, "/")
+	if len(methodInfos) != 3 {
+		return nil, status.Errorf(codes.Unimplemented, "malformed method name: %s", fullMethod)
+	}
+
+	service, exist := r.services[methodInfos[1]]
+	if !exist {
+		return nil, status.Errorf(codes.Unimplemented, "unknown service %s", methodInfos[1])
+	}
+
+	for i := range service.desc.Methods {
+		method := &service.desc.Methods[i]
+		if method.MethodName != methodInfos[2] {
+			continue
+		}
+
+		return method.Handler(service.impl, inProcessContext(ctx), dec, r.unaryInterceptor)
+	}
+
+	return nil, status.Errorf(codes.Unimplemented, "unknown method %s for service %s", methodInfos[2], methodInfos[1])
+}
+
+// InvokeStream runs a registered streaming method in-process on stream through the server stream interceptor chain,
+// the context of stream is replaced by ctx propagated like an outgoing rpc
+func (r *RpcServer) InvokeStream(ctx context.Context, fullMethod string, stream grpc.ServerStream) error {
+	methodInfos := strings.Split(fullMethod, "/")
+	if len(methodInfos) != 3 {
+		return status.Errorf(codes.Unimplemented, "malformed method name: %s", fullMethod)
+	}
+
+	service, exist := r.services[methodInfos[1]]
+	if !exist {
+		return status.Errorf(codes.Unimplemented, "unknown service %s", methodInfos[1])
+	}
+
+	for i := range service.desc.Streams {
+		streamDesc := &service.desc.Streams[i]
+		if streamDesc.StreamName != methodInfos[2] {
+			continue
+		}
+
+		info := &grpc.StreamServerInfo{FullMethod: fullMethod, IsClientStream: streamDesc.ClientStreams, IsServerStream: streamDesc.ServerStreams}
+		return r.streamInterceptor(service.impl, &inProcessStream{ServerStream: stream, ctx: inProcessContext(ctx)}, info, streamDesc.Handler)
+	}
+
+	return status.Errorf(codes.Unimplemented, "unknown method %s for service %s", methodInfos[2], methodInfos[1])
+}
+
+// ServiceDescs lists the services registered by RegisterService
+func (r *RpcServer) ServiceDescs() []*grpc.ServiceDesc {
+	descs := make([]*grpc.ServiceDesc, 0, len(r.services))
+	for _, service := range r.services {
+		descs = append(descs, service.desc)
+	}
+
+	return descs
+}
+
+type inProcessStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *inProcessStream) Context() context.Context {
+	return s.ctx
+}
+
+// inProcessContext turns the caller context into an incoming rpc context, the caller becomes the upstream,
+// incoming metadata already in ctx is kept unless context0 has the same key
+func inProcessContext(ctx context.Context) context.Context {
+	ctx = context0.Copy(ctx)
+	if currentService, exist := context0.Get(ctx, context0.ContextKeyCurrentService); exist {
+		context0.Set(ctx, context0.ContextKeyUpstreamService, currentService)
+	}
+	if currentMethod, exist := context0.Get(ctx, context0.ContextKeyCurrentMethod); exist {
+		context0.Set(ctx, context0.ContextKeyUpstreamMethod, currentMethod)
+	}
+	context0.Del(ctx, context0.ContextKeyUpstreamAddress)
+
+	meta, _ := metadata.FromOutgoingContext(context0.Prepare(ctx))
+	meta = meta.Copy()
+	if incoming, ok := metadata.FromIncomingContext(ctx); ok {
+		for key, values := range incoming {
+			if _, exist := meta[key]; !exist {
+				meta[key] = values
+			}
+		}
+	}
+
+	return metadata.NewIncomingContext(ctx, meta)
+}
+
+// Serve listens on the first free port from 8888 and blocks until the server stops,
+// it returns nil after Shutdown
+func (r *RpcServer) Serve() error {
 	startPort, tryCount := 8888, 1000
 	for i := 0; i < tryCount; i++ {
 		port := startPort + i
@@ -42,29 +214,112 @@ func (r *RpcServer) Serve() {
 			continue
 		}
 
+		r.port = uint16(port)
 		r.listener = listenerTemp
 		log.Infof(context.TODO(), "server:%v listen at:%d", r.name, port)
 
+		registry := r.registry
+		if registry == nil {
+			registry = discovery.Default()
+		}
+
+		heartbeat, err := discovery.StartHeartbeat(context0.NewContext(), registry,
+			discovery.Instance{Service: r.name, Address: discovery.AdvertiseAddress(listenerTemp.Addr())}, discovery.DEFAULT_TTL)
+		if err != nil {
+			_ = listenerTemp.Close()
+			return errors.Wrapf(err, "server:%s register fail", r.name)
+		}
+
+		r.lock.Lock()
+		r.heartbeat = heartbeat
+		r.lock.Unlock()
+
 		if err := r.server.Serve(r.listener); err != nil {
-			log.Panicf(context.TODO(), "failed to serve: %v", err)
+			log.Warningf(context.TODO(), "server:%v failed to serve: %v", r.name, err)
+			// the address is dead, clients must not be routed here any more
+			r.stopHeartbeat(context.TODO())
+			return errors.Wrapf(err, "server:%s serve fail", r.name)
 		}
+
+		return nil
 	}
+
+	return errors.Errorf("server:%s listen fail too much", r.name)
 }
 
-func (r *RpcServer) GetRpcServiceConnection(serviceName string) (*grpc.ClientConn, error) {
-	//TODO: add service discover logic...
-	address := "127.0.0.1:8088"
-	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(insecure.NewCredentials()), interceptor.WithClientUnaryInterceptor())
-	if err != nil {
-		if conn != nil {
-			_ = conn.Close()
-		}
+func (r *RpcServer) stopHeartbeat(ctx context.Context) {
+	r.lock.Lock()
+	heartbeat := r.heartbeat
+	r.heartbeat = nil
+	r.lock.Unlock()
+
+	if heartbeat == nil {
+		return
+	}
+
+	if err := heartbeat.Stop(ctx); err != nil {
+		log.Warningf(ctx, "server:%v deregister fail:%v", r.name, err)
+	}
+}
+
+// SetRegistry sets where Serve registers the server, discovery.Default() if not set
+func (r *RpcServer) SetRegistry(registry discovery.Registry) {
+	r.registry = registry
+}
+
+func (r *RpcServer) Port() uint16 {
+	return r.port
+}
+
+// OnBeforeDrain adds a hook run by Shutdown before in-flight rpcs are drained, e.g. to deregister the service
+func (r *RpcServer) OnBeforeDrain(hook func(context.Context)) {
+	r.onBeforeDrain = append(r.onBeforeDrain, hook)
+}
+
+// OnAfterDrain adds a hook run by Shutdown after the server stopped, e.g. to close downstream connections
+func (r *RpcServer) OnAfterDrain(hook func(context.Context)) {
+	r.onAfterDrain = append(r.onAfterDrain, hook)
+}
+
+// Shutdown stops accepting rpcs and waits for the in-flight ones, they are cancelled by Stop when ctx is done first
+func (r *RpcServer) Shutdown(ctx context.Context) error {
+	// health checks fail from now on so that orchestrators and balancers stop sending rpcs,
+	// the Watch streams end as GracefulStop would wait for them
+	r.health.Shutdown()
+
+	// deregister first so that no new rpcs are routed here while draining
+	r.stopHeartbeat(ctx)
+
+	for _, hook := range r.onBeforeDrain {
+		hook(ctx)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		r.server.GracefulStop()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Warningf(ctx, "server:%v graceful stop timeout, force stop", r.name)
+		r.server.Stop()
+		<-done
+		err = ctx.Err()
+	}
+
+	for _, hook := range r.onAfterDrain {
+		hook(ctx)
+	}
 
-		log.Fatalf(context.TODO(), "connect to %s-%v fail: %v", serviceName, address, err)
-		return nil, err
+	if errClose := r.CloseConnections(); errClose != nil && err == nil {
+		err = errClose
 	}
 
-	return conn, nil
+	log.Infof(ctx, "server:%v shutdown err:%v", r.name, err)
+	return err
 }
 
 // TODO：log and ip trace
@@ -0,0 +1,127 @@
+package rpc_server
+
+import (
+	"context"
+	"github.com/RealJonathanYip/framework/discovery"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/status"
+	"net"
+	"testing"
+	"time"
+)
+
+// testService blocks UnaryCall until release is closed when started is set
+type testService struct {
+	testpb.UnimplementedTestServiceServer
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *testService) UnaryCall(ctx context.Context, req *testpb.SimpleRequest) (*testpb.SimpleResponse, error) {
+	if s.started != nil {
+		s.started <- struct{}{}
+		select {
+		case <-s.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	return &testpb.SimpleResponse{Username: req.GetResponseStatus().GetMessage()}, nil
+}
+
+// serveLocal serves server on a random local port and dials it
+func serveLocal(t *testing.T, server *RpcServer) *grpc.ClientConn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen err:%v", err)
+	}
+	go server.server.Serve(listener)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dial err:%v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return conn
+}
+
+func TestShutdownDrainsRpcs(t *testing.T) {
+	service := &testService{started: make(chan struct{}, 1), release: make(chan struct{})}
+	server := New("test")
+	testpb.RegisterTestServiceServer(server, service)
+	conn := serveLocal(t, server)
+
+	var hooks []string
+	server.OnBeforeDrain(func(ctx context.Context) {
+		hooks = append(hooks, "before")
+		close(service.release)
+	})
+	server.OnAfterDrain(func(ctx context.Context) {
+		hooks = append(hooks, "after")
+	})
+
+	result := make(chan error)
+	go func() {
+		_, err := testpb.NewTestServiceClient(conn).UnaryCall(context.Background(), &testpb.SimpleRequest{})
+		result <- err
+	}()
+	<-service.started
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown err:%v", err)
+	}
+	if err := <-result; err != nil {
+		t.Fatalf("in-flight rpc err:%v", err)
+	}
+	if len(hooks) != 2 || hooks[0] != "before" || hooks[1] != "after" {
+		t.Fatalf("hooks ran as %v", hooks)
+	}
+
+	if err := server.Serve(); err == nil {
+		t.Fatal("Serve after Shutdown returned nil")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	service := &testService{started: make(chan struct{}, 1), release: make(chan struct{})}
+	server := New("test")
+	testpb.RegisterTestServiceServer(server, service)
+	conn := serveLocal(t, server)
+
+	result := make(chan error)
+	go func() {
+		_, err := testpb.NewTestServiceClient(conn).UnaryCall(context.Background(), &testpb.SimpleRequest{})
+		result <- err
+	}()
+	<-service.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown err:%v", err)
+	}
+	if err := <-result; status.Code(err) != codes.Unavailable && status.Code(err) != codes.Canceled {
+		t.Fatalf("in-flight rpc err:%v, want it cancelled by Stop", err)
+	}
+}
+
+func TestServeFailDeregisters(t *testing.T) {
+	registry := discovery.NewMemoryRegistry()
+	server := New("serve_fail")
+	server.SetRegistry(registry)
+
+	// a stopped grpc server fails to serve right after the instance is registered
+	server.server.Stop()
+	if err := server.Serve(); err == nil {
+		t.Fatal("Serve of a stopped server returned nil")
+	}
+
+	if instances, _ := registry.Resolve(context.Background(), "rpc.serve_fail"); len(instances) != 0 {
+		t.Fatalf("got %+v registered after Serve failed", instances)
+	}
+}
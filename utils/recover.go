@@ -23,6 +23,11 @@ func Recover(ctx context.Context) {
 	}
 }
 
+// Stack formats the stack of the caller like Recover logs it, skip 0 starts from the caller of Stack
+func Stack(skip int) []byte {
+	return stack(skip + 2)
+}
+
 // 打印堆栈的逻辑复制自ginex
 // stack returns a nicely formatted stack frame, skipping skip frames.
 func stack(skip int) []byte {